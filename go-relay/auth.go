@@ -0,0 +1,463 @@
+// Package main provides bearer token authentication for the relay endpoints.
+//
+// HTTP endpoints take the token in an "Authorization: Bearer <token>" header.
+// Browsers can't set headers on WebSocket requests, so /ws/* also accept it
+// in a "token" query parameter or as a subprotocol pair: the client offers
+// the protocols ["bearer", "<token>"] and the relay selects "bearer".
+//
+// Backends:
+//   - TokenFileAuthenticator - static tokens from a file, one per line
+//   - JWTAuthenticator       - JWTs signed with HS256 or RS256 by a local key
+//
+// Robots can use client certificates instead of tokens (see mtls.go).
+//
+// Every identity has a role that decides what it may do:
+//   - viewer   - receive telemetry, video and audio; commands are dropped
+//   - operator - also drive the robot and take control
+//   - admin    - also force control handovers, kick peers, reset the
+//     e-stop and change the velocity limits
+//   - robot    - connect as a Python client
+//
+// Without authentication there are no identities and everything is allowed.
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// bearerProtocol is the WebSocket subprotocol that precedes a token.
+const bearerProtocol = "bearer"
+
+// jwtLeeway tolerates clock skew when checking "exp" and "nbf".
+const jwtLeeway = 30 * time.Second
+
+// errMissingToken is returned when a request carries no token.
+var errMissingToken = errors.New("missing bearer token")
+
+// Role is the access level of an identity.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+	RoleRobot    Role = "robot"
+)
+
+// Permission is an action restricted to some roles.
+type Permission string
+
+const (
+	// PermDrive allows sending commands and holding the control lease.
+	PermDrive Permission = "drive"
+	// PermAdmin allows kicking peers, forcing control, resetting the e-stop
+	// and changing the configuration.
+	PermAdmin Permission = "admin"
+	// PermRobot allows registering as a Python (robot) client.
+	PermRobot Permission = "robot"
+)
+
+// rolePermissions lists the permissions each role holds.
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:   nil,
+	RoleOperator: {PermDrive},
+	RoleAdmin:    {PermDrive, PermAdmin},
+	RoleRobot:    {PermRobot},
+}
+
+// ParseRole validates a role name. An empty name is a viewer.
+func ParseRole(name string) (Role, error) {
+	if name == "" {
+		return RoleViewer, nil
+	}
+	role := Role(strings.ToLower(name))
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", name)
+	}
+	return role, nil
+}
+
+// Identity is an authenticated client.
+type Identity struct {
+	Subject string `json:"subject"` // Token owner ("sub" claim or token file name)
+	Role    Role   `json:"role"`    // Access level
+	Room    string `json:"room"`    // Room a client certificate is limited to (see mtls.go)
+	Cert    bool   `json:"-"`       // Authenticated by a client certificate
+}
+
+// Can reports whether the identity holds a permission. A nil identity
+// (authentication disabled) holds all of them.
+func (id *Identity) Can(perm Permission) bool {
+	if id == nil {
+		return true
+	}
+	for _, p := range rolePermissions[id.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator validates bearer tokens.
+type Authenticator interface {
+	// Authenticate returns the identity a token belongs to.
+	Authenticate(token string) (*Identity, error)
+}
+
+// TokenFileAuthenticator accepts the static tokens listed in a file.
+type TokenFileAuthenticator struct {
+	identities map[[sha256.Size]byte]Identity // Token hash -> identity
+}
+
+// NewTokenFileAuthenticator loads a token file. Each line holds a token, the
+// subject it identifies and optionally its role (default: viewer), separated
+// by whitespace; blank lines and lines starting with '#' are skipped.
+func NewTokenFileAuthenticator(path string) (*TokenFileAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &TokenFileAuthenticator{identities: make(map[[sha256.Size]byte]Identity)}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"<token> <subject> [role]\"", path, i+1)
+		}
+		identity := Identity{Subject: fields[1], Role: RoleViewer}
+		if len(fields) == 3 {
+			if identity.Role, err = ParseRole(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+		}
+		a.identities[sha256.Sum256([]byte(fields[0]))] = identity
+	}
+	if len(a.identities) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return a, nil
+}
+
+// Authenticate looks the token up. Tokens are compared by hash so lookup
+// time doesn't depend on how much of a guess matches.
+func (a *TokenFileAuthenticator) Authenticate(token string) (*Identity, error) {
+	if token == "" {
+		return nil, errMissingToken
+	}
+	identity, ok := a.identities[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, errors.New("unknown token")
+	}
+	return &identity, nil
+}
+
+// JWTAuthenticator accepts JWTs signed with a single algorithm and key.
+type JWTAuthenticator struct {
+	alg       string         // "HS256" or "RS256"
+	secret    []byte         // HS256 shared secret
+	publicKey *rsa.PublicKey // RS256 verification key
+	issuer    string         // Required "iss" claim ("" accepts any)
+	audience  string         // Required "aud" entry ("" accepts any)
+}
+
+// NewJWTAuthenticator loads the verification key for alg from keyPath: the
+// raw shared secret for HS256, or a PEM public key or certificate for RS256.
+func NewJWTAuthenticator(alg, keyPath, issuer, audience string) (*JWTAuthenticator, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &JWTAuthenticator{alg: alg, issuer: issuer, audience: audience}
+	switch alg {
+	case "HS256":
+		a.secret = []byte(strings.TrimSpace(string(data)))
+		if len(a.secret) == 0 {
+			return nil, fmt.Errorf("%s: empty secret", keyPath)
+		}
+	case "RS256":
+		if a.publicKey, err = parseRSAPublicKey(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", keyPath, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q (use HS256 or RS256)", alg)
+	}
+	return a, nil
+}
+
+// parseRSAPublicKey reads an RSA public key from a PEM "PUBLIC KEY",
+// "RSA PUBLIC KEY" or "CERTIFICATE" block.
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// jwtClaims holds the registered claims the relay checks, plus "role".
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Role      string          `json:"role"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // String or array of strings
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+// Authenticate verifies the token's signature and claims. The header must
+// name the configured algorithm, so an HS256 token can't be checked against
+// an RS256 public key.
+func (a *JWTAuthenticator) Authenticate(token string) (*Identity, error) {
+	if token == "" {
+		return nil, errMissingToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %w", err)
+	}
+	if header.Alg != a.alg {
+		return nil, fmt.Errorf("unexpected JWT algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid JWT signature encoding")
+	}
+	if err := a.verify(parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	if err := a.checkClaims(&claims, time.Now()); err != nil {
+		return nil, err
+	}
+	role, err := ParseRole(claims.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Subject: claims.Subject, Role: role}, nil
+}
+
+// verify checks a JWT signature over the signing input.
+func (a *JWTAuthenticator) verify(input string, signature []byte) error {
+	switch a.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write([]byte(input))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errors.New("invalid JWT signature")
+		}
+	case "RS256":
+		digest := sha256.Sum256([]byte(input))
+		if err := rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid JWT signature")
+		}
+	}
+	return nil
+}
+
+// checkClaims validates the subject, validity window, issuer and audience.
+func (a *JWTAuthenticator) checkClaims(claims *jwtClaims, now time.Time) error {
+	if claims.Subject == "" {
+		return errors.New("JWT has no subject")
+	}
+	if claims.ExpiresAt != nil && now.After(unixTime(*claims.ExpiresAt).Add(jwtLeeway)) {
+		return errors.New("JWT expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)) {
+		return errors.New("JWT not yet valid")
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return fmt.Errorf("unexpected JWT issuer %q", claims.Issuer)
+	}
+	if a.audience != "" && !audienceContains(claims.Audience, a.audience) {
+		return errors.New("JWT audience does not include the relay")
+	}
+	return nil
+}
+
+// decodeJWTPart decodes a base64url JSON segment of a JWT.
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// audienceContains reports whether an "aud" claim names want.
+func audienceContains(aud json.RawMessage, want string) bool {
+	var single string
+	if json.Unmarshal(aud, &single) == nil {
+		return single == want
+	}
+	var list []string
+	if json.Unmarshal(aud, &list) == nil {
+		for _, entry := range list {
+			if entry == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unixTime converts a NumericDate claim to a time.
+func unixTime(seconds float64) time.Time {
+	return time.UnixMilli(int64(seconds * 1000))
+}
+
+// loadAuthenticator builds the authenticator selected by AUTH_MODE.
+// Returns nil when authentication is disabled.
+func loadAuthenticator() (Authenticator, error) {
+	switch mode := os.Getenv("AUTH_MODE"); mode {
+	case "", "none":
+		return nil, nil
+	case "token":
+		return NewTokenFileAuthenticator(os.Getenv("AUTH_TOKEN_FILE"))
+	case "jwt":
+		alg := os.Getenv("AUTH_JWT_ALG")
+		if alg == "" {
+			alg = "HS256"
+		}
+		return NewJWTAuthenticator(alg, os.Getenv("AUTH_JWT_KEY_FILE"),
+			os.Getenv("AUTH_JWT_ISSUER"), os.Getenv("AUTH_JWT_AUDIENCE"))
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q (use none, token or jwt)", mode)
+	}
+}
+
+// bearerToken returns the token from a request's Authorization header.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// webSocketToken returns the token of a WebSocket upgrade request: the
+// Authorization header, the "token" query parameter, or the protocol that
+// follows "bearer" in Sec-WebSocket-Protocol.
+func webSocketToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == bearerProtocol {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// identityKey is the request context key for the authenticated identity.
+type identityKey struct{}
+
+// requestIdentity returns the identity requireAuth attached to a request,
+// or nil when authentication is disabled and no client certificate was sent.
+func requestIdentity(r *http.Request) *Identity {
+	identity, _ := r.Context().Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// requireAuth wraps a handler so it only runs for requests with a valid
+// bearer token or client certificate, with the identity attached to the
+// request context. Without an authenticator every request passes.
+func requireAuth(auth Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if identity := certificateIdentity(r); identity != nil {
+			next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+			return
+		}
+		if auth == nil {
+			next(w, r)
+			return
+		}
+
+		identity, err := auth.Authenticate(bearerToken(r))
+		if err != nil {
+			log.Printf("[Auth] Rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="relay"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Unauthorized", Details: err.Error()})
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	}
+}
+
+// sameIdentity reports whether a request's identity may act on a resource
+// owned by owner. Resources created without authentication have no owner.
+// A robot certificate and a token with the same subject are different owners.
+func sameIdentity(owner, identity *Identity) bool {
+	return owner == nil || (identity != nil && identity.Subject == owner.Subject && identity.Cert == owner.Cert)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signJWT builds a JWT with the given header and claims. sign returns the
+// signature over the signing input, or nil for an unsigned token.
+func signJWT(t *testing.T, header, claims map[string]interface{}, sign func(input []byte) []byte) string {
+	t.Helper()
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	input := encode(header) + "." + encode(claims)
+	var signature []byte
+	if sign != nil {
+		signature = sign([]byte(input))
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// hs256 signs with an HMAC-SHA256 secret.
+func hs256(secret []byte) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+// rs256 signs with an RSA private key.
+func rs256(t *testing.T, key *rsa.PrivateKey) func([]byte) []byte {
+	return func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+}
+
+func TestJWTAuthenticatorHS256(t *testing.T) {
+	secret := []byte("relay-test-secret")
+	auth := &JWTAuthenticator{alg: "HS256", secret: secret, issuer: "issuer", audience: "relay"}
+
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "role": "operator", "iss": "issuer", "aud": "relay", "exp": now + 3600}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", signJWT(t, hs, claims(nil), hs256(secret)), true},
+		{"audience list", signJWT(t, hs, claims(map[string]interface{}{"aud": []string{"other", "relay"}}), hs256(secret)), true},
+		{"within leeway", signJWT(t, hs, claims(map[string]interface{}{"exp": now - 10}), hs256(secret)), true},
+		{"expired", signJWT(t, hs, claims(map[string]interface{}{"exp": now - 3600}), hs256(secret)), false},
+		{"not yet valid", signJWT(t, hs, claims(map[string]interface{}{"nbf": now + 3600}), hs256(secret)), false},
+		{"wrong secret", signJWT(t, hs, claims(nil), hs256([]byte("other-secret"))), false},
+		{"alg none", signJWT(t, map[string]interface{}{"alg": "none"}, claims(nil), nil), false},
+		{"alg none with signature", signJWT(t, map[string]interface{}{"alg": "none"}, claims(nil), hs256(secret)), false},
+		{"empty signature", signJWT(t, hs, claims(nil), nil), false},
+		{"wrong issuer", signJWT(t, hs, claims(map[string]interface{}{"iss": "evil"}), hs256(secret)), false},
+		{"wrong audience", signJWT(t, hs, claims(map[string]interface{}{"aud": "other"}), hs256(secret)), false},
+		{"no subject", signJWT(t, hs, claims(map[string]interface{}{"sub": ""}), hs256(secret)), false},
+		{"unknown role", signJWT(t, hs, claims(map[string]interface{}{"role": "root"}), hs256(secret)), false},
+		{"malformed", "not.a-jwt", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := auth.Authenticate(tt.token)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Authenticate() error = %v, want ok = %v", err, tt.ok)
+			}
+			if tt.ok && (identity.Subject != "alice" || identity.Role != RoleOperator) {
+				t.Errorf("Authenticate() = %+v, want alice as operator", identity)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	keyPath := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(keyPath, pemKey, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewJWTAuthenticator("RS256", keyPath, "", "")
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator() error = %v", err)
+	}
+
+	claims := map[string]interface{}{"sub": "robot1", "role": "robot", "exp": time.Now().Unix() + 3600}
+	rs := map[string]interface{}{"alg": "RS256", "typ": "JWT"}
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", signJWT(t, rs, claims, rs256(t, key)), true},
+		{"other key", signJWT(t, rs, claims, rs256(t, other)), false},
+		// Classic confusion attack: HMAC keyed with the public key
+		{"HS256 with public key PEM", signJWT(t, hs, claims, hs256(pemKey)), false},
+		{"HS256 with public key DER", signJWT(t, hs, claims, hs256(der)), false},
+		{"RS256 header with HMAC signature", signJWT(t, rs, claims, hs256(pemKey)), false},
+		{"alg none", signJWT(t, map[string]interface{}{"alg": "none"}, claims, nil), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := auth.Authenticate(tt.token)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Authenticate() error = %v, want ok = %v", err, tt.ok)
+			}
+			if tt.ok && (identity.Subject != "robot1" || identity.Role != RoleRobot) {
+				t.Errorf("Authenticate() = %+v, want robot1 as robot", identity)
+			}
+		})
+	}
+}
+
+func TestNewJWTAuthenticatorRejectsUnsupportedAlgorithms(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(keyPath, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range []string{"none", "HS512", "ES256", ""} {
+		if _, err := NewJWTAuthenticator(alg, keyPath, "", ""); err == nil {
+			t.Errorf("NewJWTAuthenticator(%q) succeeded, want an error", alg)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package main provides the latching emergency stop.
+//
+// Unlike a zero Twist, which just means "no keys pressed", an emergency stop
+// latches the relay into a stopped state. While latched, the relay sends zero
+// Twists to every Python client at a fixed rate and drops all operator motion
+// commands. The latch clears only on an explicit reset, which can be
+// restricted to a set of roles.
+//
+// E-stop message format (JSON, over DataChannel or /ws/data):
+//
+//	{"type": "estop", "action": "engage"}
+//	{"type": "estop", "action": "reset"}
+//	{"type": "estop", "action": "state", "engaged": true, "source": "abc123"}
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// E-stop message actions.
+const (
+	EStopActionEngage = "engage" // Client -> relay: latch the e-stop
+	EStopActionReset  = "reset"  // Client -> relay: clear the latch
+	EStopActionState  = "state"  // Relay -> all clients: latch state changed
+)
+
+// EStopMessage is the JSON message used to engage, reset and report the e-stop.
+type EStopMessage struct {
+	Type      string `json:"type"`                // Always "estop"
+	Action    string `json:"action"`              // See EStopAction* constants
+	Engaged   bool   `json:"engaged"`             // Whether the latch is set
+	Source    string `json:"source,omitempty"`    // Who engaged the latch
+	Since     int64  `json:"since,omitempty"`     // When the latch was set (ms since epoch)
+	Timestamp int64  `json:"timestamp,omitempty"` // Message timestamp
+}
+
+// Encode marshals the e-stop message to JSON.
+func (m *EStopMessage) Encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// EStopState is a snapshot of the latch.
+type EStopState struct {
+	Engaged bool   `json:"engaged"`          // Whether the latch is set
+	Source  string `json:"source,omitempty"` // Who engaged the latch
+	Since   int64  `json:"since,omitempty"`  // When the latch was set (ms since epoch)
+}
+
+// EStop is a latching emergency stop that emits stop commands while engaged.
+// Thread-safe for concurrent access from multiple goroutines.
+type EStop struct {
+	period     time.Duration   // Interval between stop commands while latched
+	resetRoles map[string]bool // Roles allowed to reset (empty = any)
+	emit       func()          // Sends one stop command to the robot
+
+	mu      sync.Mutex
+	engaged bool
+	source  string
+	since   time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewEStop creates an e-stop that calls emit at rateHz while engaged.
+func NewEStop(rateHz float64, resetRoles []string, emit func()) *EStop {
+	roles := make(map[string]bool)
+	for _, role := range resetRoles {
+		roles[role] = true
+	}
+
+	return &EStop{
+		period:     time.Duration(float64(time.Second) / rateHz),
+		resetRoles: roles,
+		emit:       emit,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start launches the loop that repeats stop commands while latched.
+func (e *EStop) Start() {
+	go func() {
+		ticker := time.NewTicker(e.period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if e.Engaged() {
+					e.emit()
+				}
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the repeat loop. The latch state is left unchanged.
+func (e *EStop) Stop() {
+	e.once.Do(func() {
+		close(e.stop)
+	})
+}
+
+// Engage latches the e-stop. Returns false if it was already engaged.
+func (e *EStop) Engage(source string) bool {
+	e.mu.Lock()
+	if e.engaged {
+		e.mu.Unlock()
+		return false
+	}
+	e.engaged = true
+	e.source = source
+	e.since = time.Now()
+	e.mu.Unlock()
+
+	log.Printf("[EStop] Engaged by %s", source)
+
+	// Stop the robot right away rather than waiting for the next tick
+	e.emit()
+	return true
+}
+
+// CanReset reports whether a client with the given role may clear the latch.
+func (e *EStop) CanReset(role string) bool {
+	return len(e.resetRoles) == 0 || e.resetRoles[role]
+}
+
+// Reset clears the latch. Returns false if it wasn't engaged.
+func (e *EStop) Reset(source string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.engaged {
+		return false
+	}
+	e.engaged = false
+	e.source = ""
+	e.since = time.Time{}
+
+	log.Printf("[EStop] Reset by %s", source)
+	return true
+}
+
+// Engaged reports whether the latch is set.
+func (e *EStop) Engaged() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.engaged
+}
+
+// State returns a snapshot of the latch.
+func (e *EStop) State() EStopState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := EStopState{Engaged: e.engaged, Source: e.source}
+	if e.engaged {
+		state.Since = e.since.UnixMilli()
+	}
+	return state
+}
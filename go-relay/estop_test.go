@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestEStopLatch(t *testing.T) {
+	emitted := 0
+	e := NewEStop(10, nil, func() { emitted++ })
+
+	if !e.Engage("a") {
+		t.Fatal("Engage() of a clear latch = false, want true")
+	}
+	if emitted != 1 {
+		t.Errorf("Engage() emitted %d stops, want 1 right away", emitted)
+	}
+
+	// Engaging again keeps the original source
+	if e.Engage("b") {
+		t.Errorf("Engage() of a set latch = true, want false")
+	}
+	state := e.State()
+	if !state.Engaged || state.Source != "a" || state.Since == 0 {
+		t.Errorf("State() = %+v, want engaged by a", state)
+	}
+
+	if !e.Reset("admin") {
+		t.Errorf("Reset() of a set latch = false, want true")
+	}
+	if e.Reset("admin") {
+		t.Errorf("Reset() of a clear latch = true, want false")
+	}
+	if state := e.State(); state != (EStopState{}) {
+		t.Errorf("State() after Reset() = %+v, want clear", state)
+	}
+}
+
+func TestEStopRepeatsWhileEngaged(t *testing.T) {
+	emitted := make(chan struct{}, 16)
+	e := NewEStop(100, nil, func() {
+		select {
+		case emitted <- struct{}{}:
+		default:
+		}
+	})
+	e.Start()
+	defer e.Stop()
+
+	e.Engage("a")
+	for i := 0; i < 3; i++ {
+		select {
+		case <-emitted:
+		case <-time.After(time.Second):
+			t.Fatalf("got %d stops while engaged, want repeated stops", i)
+		}
+	}
+}
+
+func TestEStopCanReset(t *testing.T) {
+	tests := []struct {
+		name       string
+		resetRoles []string
+		role       Role
+		want       bool
+	}{
+		{"any role", nil, RoleViewer, true},
+		{"admin only, admin", []string{string(RoleAdmin)}, RoleAdmin, true},
+		{"admin only, operator", []string{string(RoleAdmin)}, RoleOperator, false},
+		{"admin only, viewer", []string{string(RoleAdmin)}, RoleViewer, false},
+		{"admin only, no role", []string{string(RoleAdmin)}, "", false},
+		{"admin or operator", []string{string(RoleAdmin), string(RoleOperator)}, RoleOperator, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEStop(10, tt.resetRoles, func() {})
+			if got := e.CanReset(string(tt.role)); got != tt.want {
+				t.Errorf("CanReset(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterEStopReset(t *testing.T) {
+	mr := NewMessageRouter(NewPeerManager(webrtc.Configuration{}))
+	mr.EnableEStop(10, []string{string(RoleAdmin)})
+
+	if err := mr.EngageEStop("robot1", "admin"); !errors.Is(err, ErrRoomNotOpen) {
+		t.Errorf("EngageEStop() of a closed room error = %v, want %v", err, ErrRoomNotOpen)
+	}
+
+	room := mr.room("robot1")
+	defer room.Stop()
+	if err := mr.EngageEStop("robot1", "operator"); err != nil {
+		t.Fatalf("EngageEStop() error = %v", err)
+	}
+	if !mr.estopEngaged(room) {
+		t.Errorf("commands not dropped while the latch is set")
+	}
+
+	// Only admins may clear the latch
+	if err := mr.ResetEStop("robot1", "operator", string(RoleOperator)); err == nil {
+		t.Errorf("ResetEStop() by an operator succeeded, want an error")
+	}
+	if !mr.EStopState("robot1").Engaged {
+		t.Fatalf("latch cleared by an operator")
+	}
+	if err := mr.ResetEStop("robot1", "admin", string(RoleAdmin)); err != nil {
+		t.Errorf("ResetEStop() by an admin error = %v", err)
+	}
+	if mr.estopEngaged(room) {
+		t.Errorf("commands still dropped after the reset")
+	}
+}
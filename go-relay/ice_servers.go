@@ -0,0 +1,115 @@
+// Package main provides the ICE server list handed to the relay and to clients.
+//
+// STUN and TURN servers are configured as a list. Servers with long-term
+// credentials are only used by the relay itself and are never sent to
+// clients. Clients instead receive TURN credentials that expire, derived
+// from a secret shared with the TURN server (the "TURN REST API" scheme
+// supported by coturn's use-auth-secret):
+//
+//	username   = "<expiry unix time>:<user id>"
+//	credential = base64(HMAC-SHA1(secret, username))
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ICEServerConfig is one STUN or TURN server, in the same shape as the
+// browser's RTCIceServer.
+type ICEServerConfig struct {
+	URLs       []string `json:"urls"`                 // stun: / turn: / turns: URLs
+	Username   string   `json:"username,omitempty"`   // TURN username
+	Credential string   `json:"credential,omitempty"` // TURN password
+}
+
+// ParseICEServers parses a JSON list of ICE servers, e.g.
+// [{"urls": ["turn:turn.example.com:3478"], "username": "u", "credential": "p"}].
+func ParseICEServers(data string) ([]ICEServerConfig, error) {
+	var servers []ICEServerConfig
+	if err := json.Unmarshal([]byte(data), &servers); err != nil {
+		return nil, err
+	}
+	for i, server := range servers {
+		if len(server.URLs) == 0 {
+			return nil, fmt.Errorf("server %d has no urls", i)
+		}
+	}
+	return servers, nil
+}
+
+// TURNCredentials derives time-limited TURN credentials for user from the
+// shared secret. The credentials expire ttl after now.
+func TURNCredentials(secret, user string, ttl time.Duration, now time.Time) (username, credential string) {
+	username = strconv.FormatInt(now.Add(ttl).Unix(), 10) + ":" + user
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, credential
+}
+
+// ICEServers holds the configured ICE servers.
+type ICEServers struct {
+	servers  []ICEServerConfig // Static STUN/TURN servers
+	turnURLs []string          // TURN servers that accept shared-secret credentials
+	secret   string            // Shared secret for client credentials ("" = none)
+	ttl      time.Duration     // Lifetime of client credentials
+}
+
+// NewICEServers creates the ICE server list. If secret is set, clients
+// receive credentials for turnURLs that expire after ttl.
+func NewICEServers(servers []ICEServerConfig, turnURLs []string, secret string, ttl time.Duration) *ICEServers {
+	return &ICEServers{
+		servers:  servers,
+		turnURLs: turnURLs,
+		secret:   secret,
+		ttl:      ttl,
+	}
+}
+
+// WebRTC returns the servers used by the relay's own peer connections.
+func (s *ICEServers) WebRTC() []webrtc.ICEServer {
+	result := make([]webrtc.ICEServer, 0, len(s.servers))
+	for _, server := range s.servers {
+		result = append(result, webrtc.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		})
+	}
+	return result
+}
+
+// ForClient returns the servers to hand to a client. Static servers with
+// credentials are left out; TURN servers get fresh time-limited credentials.
+func (s *ICEServers) ForClient(user string, now time.Time) []ICEServerConfig {
+	result := []ICEServerConfig{}
+	for _, server := range s.servers {
+		if server.Username == "" && server.Credential == "" {
+			result = append(result, server)
+		}
+	}
+
+	if s.secret != "" && len(s.turnURLs) > 0 {
+		username, credential := TURNCredentials(s.secret, user, s.ttl, now)
+		result = append(result, ICEServerConfig{
+			URLs:       s.turnURLs,
+			Username:   username,
+			Credential: credential,
+		})
+	}
+	return result
+}
+
+// TTL returns the lifetime of client TURN credentials.
+func (s *ICEServers) TTL() time.Duration {
+	return s.ttl
+}
@@ -0,0 +1,201 @@
+// Package main provides the exclusive control lease for robot teleoperation.
+//
+// Only one web client (WebRTC peer or WebSocket client) may drive the robot
+// at a time. A client requests control with a control message and, if the
+// lease is free, becomes the holder for a TTL that is renewed by every Twist
+// it sends. Twists from non-holders are dropped by the router. All web
+// clients are told who holds control whenever it changes.
+//
+// Control message format (JSON, over DataChannel or /ws/data):
+//
+//	{"type": "control", "action": "request"}
+//	{"type": "control", "action": "release"}
+//	{"type": "control", "action": "holder", "holder_id": "abc123", "ttl_ms": 10000}
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Control message actions.
+const (
+	ControlActionRequest = "request" // Client -> relay: ask for control
+	ControlActionRelease = "release" // Client -> relay: give up control
+	ControlActionGranted = "granted" // Relay -> client: request accepted
+	ControlActionDenied  = "denied"  // Relay -> client: someone else holds control
+	ControlActionHolder  = "holder"  // Relay -> all web clients: holder changed
+)
+
+// ControlMessage is the JSON message used to negotiate the control lease.
+type ControlMessage struct {
+	Type      string `json:"type"`                // Always "control"
+	Action    string `json:"action"`              // See ControlAction* constants
+	HolderID  string `json:"holder_id,omitempty"` // Current holder ("" if free)
+	TTLMs     int64  `json:"ttl_ms,omitempty"`    // Lease TTL in milliseconds
+	Timestamp int64  `json:"timestamp,omitempty"` // Message timestamp
+}
+
+// Encode marshals the control message to JSON.
+func (m *ControlMessage) Encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// ControlLease tracks which web source currently holds control of the robot.
+// Thread-safe for concurrent access from multiple goroutines.
+type ControlLease struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	holder   string    // Source ID of the holder ("" if free)
+	expires  time.Time // When the lease lapses without renewal
+	onExpire func(holderID string)
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewControlLease creates a lease with the given TTL.
+// onExpire is called when a holder's lease lapses without renewal.
+func NewControlLease(ttl time.Duration, onExpire func(holderID string)) *ControlLease {
+	return &ControlLease{
+		ttl:      ttl,
+		onExpire: onExpire,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start launches the background expiry loop.
+func (l *ControlLease) Start() {
+	interval := l.ttl / 4
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				l.expire(now)
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+
+	log.Printf("[Lease] Started (ttl: %s)", l.ttl)
+}
+
+// Stop terminates the background expiry loop.
+func (l *ControlLease) Stop() {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+}
+
+// TTL returns the lease duration.
+func (l *ControlLease) TTL() time.Duration {
+	return l.ttl
+}
+
+// Holder returns the current holder ID, or "" if the lease is free.
+func (l *ControlLease) Holder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder != "" && time.Now().After(l.expires) {
+		return ""
+	}
+	return l.holder
+}
+
+// Acquire grants the lease to id if it is free, expired or already held by id.
+// Returns the resulting holder and whether id now holds the lease.
+func (l *ControlLease) Acquire(id string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.holder != "" && l.holder != id && now.Before(l.expires) {
+		return l.holder, false
+	}
+
+	l.holder = id
+	l.expires = now.Add(l.ttl)
+	return id, true
+}
+
+// Renew extends the lease if id holds it.
+// Returns false if id is not the current holder.
+func (l *ControlLease) Renew(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.holder != id || now.After(l.expires) {
+		return false
+	}
+	l.expires = now.Add(l.ttl)
+	return true
+}
+
+// Extend keeps the lease from lapsing for at least d if id holds it, e.g.
+// while the holder reconnects. Returns false if id is not the current holder.
+func (l *ControlLease) Extend(id string, d time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.holder != id || now.After(l.expires) {
+		return false
+	}
+	if until := now.Add(d); until.After(l.expires) {
+		l.expires = until
+	}
+	return true
+}
+
+// Release frees the lease if id holds it. Returns true if it was released.
+func (l *ControlLease) Release(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder != id {
+		return false
+	}
+	l.holder = ""
+	return true
+}
+
+// Force hands the lease to id regardless of the current holder.
+// An empty id revokes the lease. Returns the previous holder.
+func (l *ControlLease) Force(id string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prev := l.holder
+	l.holder = id
+	l.expires = time.Now().Add(l.ttl)
+	return prev
+}
+
+// expire frees the lease if its holder stopped renewing it.
+func (l *ControlLease) expire(now time.Time) {
+	l.mu.Lock()
+	holder := l.holder
+	if holder == "" || now.Before(l.expires) {
+		l.mu.Unlock()
+		return
+	}
+	l.holder = ""
+	l.mu.Unlock()
+
+	log.Printf("[Lease] Lease of %s expired", holder)
+	if l.onExpire != nil {
+		l.onExpire(holder)
+	}
+}
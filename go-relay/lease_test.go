@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestControlLease(t *testing.T) {
+	l := NewControlLease(time.Minute, nil)
+
+	steps := []struct {
+		name   string
+		op     func() bool
+		want   bool
+		holder string // Holder after the step
+	}{
+		{"acquire free", func() bool { _, ok := l.Acquire("a"); return ok }, true, "a"},
+		{"acquire held", func() bool { _, ok := l.Acquire("b"); return ok }, false, "a"},
+		{"reacquire own", func() bool { _, ok := l.Acquire("a"); return ok }, true, "a"},
+		{"renew by holder", func() bool { return l.Renew("a") }, true, "a"},
+		{"renew by other", func() bool { return l.Renew("b") }, false, "a"},
+		{"extend by other", func() bool { return l.Extend("b", time.Hour) }, false, "a"},
+		{"release by other", func() bool { return l.Release("b") }, false, "a"},
+		{"release by holder", func() bool { return l.Release("a") }, true, ""},
+		{"renew after release", func() bool { return l.Renew("a") }, false, ""},
+		{"force", func() bool { return l.Force("b") == "" }, true, "b"},
+		{"force revoke", func() bool { return l.Force("") == "b" }, true, ""},
+	}
+
+	for _, step := range steps {
+		if got := step.op(); got != step.want {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+		if holder := l.Holder(); holder != step.holder {
+			t.Errorf("%s: holder %q, want %q", step.name, holder, step.holder)
+		}
+	}
+}
+
+func TestControlLeaseExpire(t *testing.T) {
+	tests := []struct {
+		name    string
+		extend  time.Duration // Extension granted to the holder (0 = none)
+		elapsed time.Duration // Time until expire runs
+		expired bool
+	}{
+		{"within ttl", 0, 30 * time.Second, false},
+		{"past ttl", 0, 2 * time.Minute, true},
+		{"extended", time.Hour, 2 * time.Minute, false},
+		{"past extension", time.Hour, 2 * time.Hour, true},
+		{"extension shorter than ttl", time.Second, 30 * time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expired []string
+			l := NewControlLease(time.Minute, func(holderID string) { expired = append(expired, holderID) })
+
+			start := time.Now()
+			l.Acquire("a")
+			if tt.extend > 0 && !l.Extend("a", tt.extend) {
+				t.Fatal("Extend() by the holder failed")
+			}
+			l.expire(start.Add(tt.elapsed))
+
+			if tt.expired && (len(expired) != 1 || expired[0] != "a") {
+				t.Errorf("onExpire calls = %v, want [a]", expired)
+			}
+			if !tt.expired && len(expired) != 0 {
+				t.Errorf("onExpire calls = %v, want none", expired)
+			}
+
+			// A second pass finds nothing left to expire
+			l.expire(start.Add(tt.elapsed))
+			if len(expired) > 1 {
+				t.Errorf("onExpire called %d times, want at most once", len(expired))
+			}
+		})
+	}
+}
+
+func TestControlLeaseAcquireExpired(t *testing.T) {
+	l := NewControlLease(10*time.Millisecond, nil)
+	l.Acquire("a")
+	time.Sleep(20 * time.Millisecond)
+
+	if holder := l.Holder(); holder != "" {
+		t.Errorf("Holder() of a lapsed lease = %q, want \"\"", holder)
+	}
+	if l.Renew("a") {
+		t.Errorf("Renew() of a lapsed lease succeeded")
+	}
+	if _, ok := l.Acquire("b"); !ok {
+		t.Errorf("Acquire() of a lapsed lease failed")
+	}
+}
+
+func TestCheckLeaseAutoAcquire(t *testing.T) {
+	tests := []struct {
+		name        string
+		autoAcquire bool
+		holder      string // Holder before the Twist ("" = free)
+		want        bool
+		wantHolder  string
+	}{
+		{"holder", false, "operator", true, "operator"},
+		{"free, no auto-acquire", false, "", false, ""},
+		{"free, auto-acquire", true, "", true, "operator"},
+		{"held by another", true, "other", false, "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := NewMessageRouter(NewPeerManager(webrtc.Configuration{}))
+			mr.EnableLease(time.Minute, tt.autoAcquire)
+
+			room := mr.room("robot1")
+			defer room.Stop()
+			if tt.holder != "" {
+				room.lease.Acquire(tt.holder)
+			}
+
+			if got := mr.checkLease(room, "operator"); got != tt.want {
+				t.Errorf("checkLease() = %v, want %v", got, tt.want)
+			}
+			if holder := room.lease.Holder(); holder != tt.wantHolder {
+				t.Errorf("holder = %q, want %q", holder, tt.wantHolder)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+// Package main provides the velocity envelope enforced on operator commands.
+//
+// Every Twist from a web client is checked against per-axis magnitude limits,
+// a combined linear/angular speed limit and a set of axes that are forced to
+// zero (e.g. linear.z on a ground robot). Non-finite values (NaN, ±Inf) are
+// always treated as violations. Depending on the configured action, a
+// violating command is either clamped into the envelope and forwarded or
+// dropped entirely.
+//
+// One envelope applies to every room by default. Rooms whose robot needs
+// different limits get their own envelope, which replaces the default one
+// for that room entirely.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LimitAction selects what happens to a Twist that violates the envelope.
+type LimitAction string
+
+const (
+	// LimitActionClamp scales/clamps the command into the envelope and forwards it.
+	LimitActionClamp LimitAction = "clamp"
+	// LimitActionDrop discards the command.
+	LimitActionDrop LimitAction = "drop"
+)
+
+// VelocityLimits describes the allowed envelope for Twist commands.
+// A zero limit means the corresponding axis or speed is unlimited.
+type VelocityLimits struct {
+	MaxLinear       Vector3         // Max |value| per linear axis (m/s)
+	MaxAngular      Vector3         // Max |value| per angular axis (rad/s)
+	MaxLinearSpeed  float64         // Max linear vector magnitude (m/s)
+	MaxAngularSpeed float64         // Max angular vector magnitude (rad/s)
+	LockedAxes      map[string]bool // Axes forced to zero, e.g. "linear.z"
+	Action          LimitAction     // Clamp or drop on violation
+}
+
+// Apply checks twist against the envelope.
+// Returns the command to forward (twist itself if compliant, a clamped copy
+// otherwise), whether a violation occurred, and false if it must be dropped.
+func (l *VelocityLimits) Apply(twist *TwistMessage) (out *TwistMessage, violated bool, ok bool) {
+	out = twist.Clone()
+
+	violated = clampVector(&out.Linear, l.MaxLinear, "linear", l.LockedAxes)
+	if clampVector(&out.Angular, l.MaxAngular, "angular", l.LockedAxes) {
+		violated = true
+	}
+	if scaleVector(&out.Linear, l.MaxLinearSpeed) {
+		violated = true
+	}
+	if scaleVector(&out.Angular, l.MaxAngularSpeed) {
+		violated = true
+	}
+
+	if !violated {
+		return twist, false, true
+	}
+	if l.Action == LimitActionDrop {
+		return nil, true, false
+	}
+	return out, true, true
+}
+
+// clampVector clamps each axis of v into [-max, max], zeroes locked and
+// non-finite axes, and reports whether anything was changed.
+func clampVector(v *Vector3, max Vector3, prefix string, locked map[string]bool) bool {
+	changed := false
+	axes := []struct {
+		name  string
+		value *float64
+		max   float64
+	}{
+		{"x", &v.X, max.X},
+		{"y", &v.Y, max.Y},
+		{"z", &v.Z, max.Z},
+	}
+
+	for _, axis := range axes {
+		val := *axis.value
+		switch {
+		case math.IsNaN(val) || math.IsInf(val, 0):
+			*axis.value = 0
+		case locked[prefix+"."+axis.name]:
+			*axis.value = 0
+		case axis.max > 0 && val > axis.max:
+			*axis.value = axis.max
+		case axis.max > 0 && val < -axis.max:
+			*axis.value = -axis.max
+		}
+		if *axis.value != val {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// scaleVector scales v down so its magnitude doesn't exceed max.
+// Direction is preserved. Reports whether v was scaled.
+func scaleVector(v *Vector3, max float64) bool {
+	if max <= 0 {
+		return false
+	}
+
+	magnitude := math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+	if magnitude <= max {
+		return false
+	}
+
+	factor := max / magnitude
+	v.X *= factor
+	v.Y *= factor
+	v.Z *= factor
+	return true
+}
+
+// String returns a human-readable summary of the envelope.
+func (l *VelocityLimits) String() string {
+	locked := make([]string, 0, len(l.LockedAxes))
+	for axis := range l.LockedAxes {
+		locked = append(locked, axis)
+	}
+	return fmt.Sprintf("linear<=[%g %g %g] angular<=[%g %g %g] speed<=%g/%g locked=%v action=%s",
+		l.MaxLinear.X, l.MaxLinear.Y, l.MaxLinear.Z,
+		l.MaxAngular.X, l.MaxAngular.Y, l.MaxAngular.Z,
+		l.MaxLinearSpeed, l.MaxAngularSpeed, locked, l.Action)
+}
+
+// LimitsConfig is the JSON form of VelocityLimits used by /config.
+type LimitsConfig struct {
+	MaxLinear       Vector3     `json:"maxLinear"`       // Max |value| per linear axis (m/s)
+	MaxAngular      Vector3     `json:"maxAngular"`      // Max |value| per angular axis (rad/s)
+	MaxLinearSpeed  float64     `json:"maxLinearSpeed"`  // Max linear vector magnitude (m/s)
+	MaxAngularSpeed float64     `json:"maxAngularSpeed"` // Max angular vector magnitude (rad/s)
+	LockedAxes      []string    `json:"lockedAxes"`      // Axes forced to zero, e.g. "linear.z"
+	Action          LimitAction `json:"action"`          // "clamp" or "drop"
+}
+
+// Config returns the JSON form of the envelope.
+func (l *VelocityLimits) Config() LimitsConfig {
+	locked := make([]string, 0, len(l.LockedAxes))
+	for axis := range l.LockedAxes {
+		locked = append(locked, axis)
+	}
+	sort.Strings(locked)
+
+	return LimitsConfig{
+		MaxLinear:       l.MaxLinear,
+		MaxAngular:      l.MaxAngular,
+		MaxLinearSpeed:  l.MaxLinearSpeed,
+		MaxAngularSpeed: l.MaxAngularSpeed,
+		LockedAxes:      locked,
+		Action:          l.Action,
+	}
+}
+
+// VelocityLimits validates the JSON form and converts it to an envelope.
+// An empty action defaults to clamp.
+func (c *LimitsConfig) VelocityLimits() (VelocityLimits, error) {
+	values := []float64{
+		c.MaxLinear.X, c.MaxLinear.Y, c.MaxLinear.Z,
+		c.MaxAngular.X, c.MaxAngular.Y, c.MaxAngular.Z,
+		c.MaxLinearSpeed, c.MaxAngularSpeed,
+	}
+	for _, v := range values {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return VelocityLimits{}, fmt.Errorf("invalid limit %g", v)
+		}
+	}
+
+	locked, err := parseLockedAxes(strings.Join(c.LockedAxes, ","))
+	if err != nil {
+		return VelocityLimits{}, err
+	}
+
+	action := c.Action
+	switch action {
+	case "":
+		action = LimitActionClamp
+	case LimitActionClamp, LimitActionDrop:
+	default:
+		return VelocityLimits{}, fmt.Errorf("invalid action %q", action)
+	}
+
+	return VelocityLimits{
+		MaxLinear:       c.MaxLinear,
+		MaxAngular:      c.MaxAngular,
+		MaxLinearSpeed:  c.MaxLinearSpeed,
+		MaxAngularSpeed: c.MaxAngularSpeed,
+		LockedAxes:      locked,
+		Action:          action,
+	}, nil
+}
+
+// LimitsByRoom holds per-room envelopes keyed by room id.
+type LimitsByRoom map[string]VelocityLimits
+
+// ParseRoomLimits parses per-room envelopes from a JSON object keyed by room
+// id, e.g. {"robot1": {"maxLinear": {"x": 1, "y": 0, "z": 0}, "action": "drop"}}.
+func ParseRoomLimits(data string) (LimitsByRoom, error) {
+	var configs map[string]LimitsConfig
+	if err := json.Unmarshal([]byte(data), &configs); err != nil {
+		return nil, err
+	}
+
+	rooms := make(LimitsByRoom, len(configs))
+	for id, config := range configs {
+		room, err := ParseRoom(id)
+		if err != nil {
+			return nil, err
+		}
+		limits, err := config.VelocityLimits()
+		if err != nil {
+			return nil, fmt.Errorf("room %s: %w", room, err)
+		}
+		rooms[room] = limits
+	}
+	return rooms, nil
+}
+
+// parseLimitVector parses "v" (applied to all axes) or "x,y,z".
+func parseLimitVector(value string) (Vector3, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 1 && len(parts) != 3 {
+		return Vector3{}, fmt.Errorf("expected 1 or 3 values, got %d", len(parts))
+	}
+
+	nums := make([]float64, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+			return Vector3{}, fmt.Errorf("invalid limit %q", part)
+		}
+		nums[i] = n
+	}
+
+	if len(nums) == 1 {
+		return Vector3{X: nums[0], Y: nums[0], Z: nums[0]}, nil
+	}
+	return Vector3{X: nums[0], Y: nums[1], Z: nums[2]}, nil
+}
+
+// parseLockedAxes parses a comma-separated axis list like "linear.z,angular.x".
+func parseLockedAxes(value string) (map[string]bool, error) {
+	locked := make(map[string]bool)
+	for _, axis := range strings.Split(value, ",") {
+		axis = strings.ToLower(strings.TrimSpace(axis))
+		if axis == "" {
+			continue
+		}
+		switch axis {
+		case "linear.x", "linear.y", "linear.z", "angular.x", "angular.y", "angular.z":
+			locked[axis] = true
+		default:
+			return nil, fmt.Errorf("unknown axis %q", axis)
+		}
+	}
+	return locked, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestVelocityLimitsApply(t *testing.T) {
+	envelope := VelocityLimits{
+		MaxLinear:      Vector3{X: 4, Y: 4},
+		MaxAngular:     Vector3{Z: 2},
+		MaxLinearSpeed: 2.5,
+		LockedAxes:     map[string]bool{"linear.z": true},
+	}
+
+	tests := []struct {
+		name     string
+		action   LimitAction
+		in       TwistMessage
+		want     *TwistMessage // Forwarded command (nil if dropped)
+		violated bool
+	}{
+		{"inside", LimitActionClamp, TwistMessage{Linear: Vector3{X: 1.5, Y: 2}, Angular: Vector3{Z: -1}},
+			&TwistMessage{Linear: Vector3{X: 1.5, Y: 2}, Angular: Vector3{Z: -1}}, false},
+		{"inside, drop", LimitActionDrop, TwistMessage{Linear: Vector3{X: 1.5}},
+			&TwistMessage{Linear: Vector3{X: 1.5}}, false},
+		{"angular clamped", LimitActionClamp, TwistMessage{Angular: Vector3{Z: -5}},
+			&TwistMessage{Angular: Vector3{Z: -2}}, true},
+		{"angular dropped", LimitActionDrop, TwistMessage{Angular: Vector3{Z: -5}}, nil, true},
+		{"unlimited axis", LimitActionClamp, TwistMessage{Angular: Vector3{X: 100}},
+			&TwistMessage{Angular: Vector3{X: 100}}, false},
+		// Each axis is within its own limit, but not the combined speed
+		{"speed scaled", LimitActionClamp, TwistMessage{Linear: Vector3{X: 3, Y: -4}},
+			&TwistMessage{Linear: Vector3{X: 1.5, Y: -2}}, true},
+		{"speed dropped", LimitActionDrop, TwistMessage{Linear: Vector3{X: 3, Y: -4}}, nil, true},
+		{"axis clamped, then scaled", LimitActionClamp, TwistMessage{Linear: Vector3{X: -6}},
+			&TwistMessage{Linear: Vector3{X: -2.5}}, true},
+		{"locked axis", LimitActionClamp, TwistMessage{Linear: Vector3{X: 1, Z: 0.1}},
+			&TwistMessage{Linear: Vector3{X: 1}}, true},
+		{"locked axis, drop", LimitActionDrop, TwistMessage{Linear: Vector3{Z: 0.1}}, nil, true},
+		{"NaN", LimitActionClamp, TwistMessage{Linear: Vector3{X: math.NaN()}, Angular: Vector3{Z: 1}},
+			&TwistMessage{Angular: Vector3{Z: 1}}, true},
+		{"infinity", LimitActionDrop, TwistMessage{Angular: Vector3{X: math.Inf(1)}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := envelope
+			limits.Action = tt.action
+			in := tt.in
+
+			out, violated, ok := limits.Apply(&in)
+			if violated != tt.violated || ok != (tt.want != nil) {
+				t.Fatalf("Apply() violated = %v, ok = %v; want %v, %v", violated, ok, tt.violated, tt.want != nil)
+			}
+			if !reflect.DeepEqual(out, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitsConfigVelocityLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		config LimitsConfig
+		action LimitAction
+		ok     bool
+	}{
+		{"default action", LimitsConfig{MaxLinearSpeed: 1}, LimitActionClamp, true},
+		{"drop", LimitsConfig{Action: LimitActionDrop}, LimitActionDrop, true},
+		{"locked axes", LimitsConfig{LockedAxes: []string{"linear.z", "Angular.X"}}, LimitActionClamp, true},
+		{"unknown action", LimitsConfig{Action: "reject"}, "", false},
+		{"negative limit", LimitsConfig{MaxAngular: Vector3{Z: -1}}, "", false},
+		{"NaN limit", LimitsConfig{MaxLinearSpeed: math.NaN()}, "", false},
+		{"unknown axis", LimitsConfig{LockedAxes: []string{"linear.w"}}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits, err := tt.config.VelocityLimits()
+			if (err == nil) != tt.ok {
+				t.Fatalf("VelocityLimits() error = %v, want ok = %v", err, tt.ok)
+			}
+			if tt.ok && limits.Action != tt.action {
+				t.Errorf("VelocityLimits() action = %q, want %q", limits.Action, tt.action)
+			}
+		})
+	}
+}
+
+func TestParseRoomLimits(t *testing.T) {
+	rooms, err := ParseRoomLimits(`{"robot1": {"maxLinearSpeed": 0.5, "action": "drop"}, "robot2": {"lockedAxes": ["linear.y"]}}`)
+	if err != nil {
+		t.Fatalf("ParseRoomLimits() error = %v", err)
+	}
+	if len(rooms) != 2 || rooms["robot1"].MaxLinearSpeed != 0.5 || rooms["robot1"].Action != LimitActionDrop ||
+		!rooms["robot2"].LockedAxes["linear.y"] || rooms["robot2"].Action != LimitActionClamp {
+		t.Errorf("ParseRoomLimits() = %+v", rooms)
+	}
+
+	for _, data := range []string{
+		`{"robot 1": {}}`,
+		`{"robot1": {"action": "reject"}}`,
+		`{"robot1": {"maxLinearSpeed": -1}}`,
+		`["robot1"]`,
+		`{`,
+	} {
+		if _, err := ParseRoomLimits(data); err == nil {
+			t.Errorf("ParseRoomLimits(%s) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestFilterCommandRoomLimits(t *testing.T) {
+	mr := NewMessageRouter(nil)
+	mr.SetLimits(VelocityLimits{MaxLinear: Vector3{X: 1}, Action: LimitActionClamp})
+	mr.SetRoomLimits("slow", &VelocityLimits{MaxLinear: Vector3{X: 0.25}, Action: LimitActionClamp})
+	mr.SetRoomLimits("strict", &VelocityLimits{MaxLinear: Vector3{X: 0.25}, Action: LimitActionDrop})
+
+	tests := []struct {
+		room string
+		x    float64
+		want float64 // Forwarded linear.x (NaN if dropped)
+	}{
+		{"default", 0.5, 0.5},
+		{"default", 2, 1},
+		{"slow", 0.5, 0.25},
+		{"slow", 0.2, 0.2},
+		{"strict", 0.5, math.NaN()},
+		{"strict", 0.2, 0.2},
+	}
+
+	for _, tt := range tests {
+		out, ok := mr.FilterCommand("client", tt.room, &TwistMessage{Linear: Vector3{X: tt.x}})
+		if math.IsNaN(tt.want) {
+			if ok {
+				t.Errorf("FilterCommand(%s, %g) = %+v, want dropped", tt.room, tt.x, out)
+			}
+			continue
+		}
+		if !ok || out.Linear.X != tt.want {
+			t.Errorf("FilterCommand(%s, %g) = %+v, %v; want linear.x %g", tt.room, tt.x, out, ok, tt.want)
+		}
+	}
+
+	// Removing the override restores the default envelope
+	mr.SetRoomLimits("strict", nil)
+	if out, ok := mr.FilterCommand("client", "strict", &TwistMessage{Linear: Vector3{X: 0.5}}); !ok || out.Linear.X != 0.5 {
+		t.Errorf("FilterCommand() after removing the override = %+v, %v; want linear.x 0.5", out, ok)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,9 +20,39 @@ import (
 
 // Server configuration
 type Config struct {
-	Port       string   // HTTP server port
-	STUNServer string   // STUN server URL
-	Origins    []string // Allowed CORS origins
+	Port             string            // HTTP server port
+	TLSCertFile      string            // PEM certificate; serves HTTPS/WSS when set
+	TLSKeyFile       string            // PEM private key for TLSCertFile
+	TLSSelfSigned    bool              // Generate a self-signed dev certificate if none exists
+	CertReload       time.Duration     // How often to check the certificate files for changes (0 disables)
+	RedirectPort     string            // Port redirecting plain HTTP to HTTPS ("" disables)
+	ClientCAFile     string            // CA for robot client certificates; requires mTLS for robots
+	ICEServers       []ICEServerConfig // STUN/TURN servers used by the relay
+	TURNURLs         []string          // TURN servers that accept shared-secret credentials
+	TURNSecret       string            // Shared secret for client TURN credentials ("" disables)
+	TURNCredTTL      time.Duration     // Lifetime of client TURN credentials
+	CommandLifetime  time.Duration     // Max retransmit time on the "cmd" channel (0 = no retransmits)
+	SessionGrace     time.Duration     // How long a failed peer or dropped data socket may resume (0 disables)
+	ReplayMessages   int               // Max messages buffered per /ws/data session for replay
+	ReplayMaxAge     time.Duration     // Max age of buffered /ws/data messages (0 = no limit)
+	Origins          []string          // Allowed browser origins (empty = same origin only)
+	OriginDevMode    bool              // Also allow localhost and file:// origins
+	WatchdogTimeout  time.Duration     // Deadman timeout for silent web sources (0 disables)
+	Limits           VelocityLimits    // Velocity envelope for operator commands
+	RoomLimits       LimitsByRoom      // Per-room envelopes replacing Limits
+	RampLinearAccel  float64           // Max linear acceleration in m/s² (0 disables ramping)
+	RampAngularAccel float64           // Max angular acceleration in rad/s² (0 disables ramping)
+	RampRate         float64           // Ramp filter output rate in Hz
+	LeaseTTL         time.Duration     // Control lease TTL, renewed by traffic (0 disables)
+	LeaseAutoAcquire bool              // Grant a free lease on a client's first Twist
+	EStopRate        float64           // Stop command rate in Hz while the e-stop is latched
+	EStopResetRoles  []string          // Roles allowed to reset the e-stop (empty = any)
+	StaleMaxAge      time.Duration     // Max command age (0 disables the age check)
+	StaleAction      StaleAction       // Drop or zero commands past StaleMaxAge
+	RejectReordered  bool              // Drop commands whose timestamp goes backwards
+	SkewCorrection   bool              // Correct command age by the per-client clock skew
+	RateLimits       RateLimitConfig   // Per-client and per-IP message and connection limits
+	MessageCaps      map[string]int    // Payload size caps by message type name, overriding the defaults
 }
 
 // loadConfig loads configuration from environment variables with defaults.
@@ -31,98 +67,264 @@ func loadConfig() *Config {
 		stunServer = "stun:stun.l.google.com:19302"
 	}
 
+	// ICE_SERVERS replaces the single STUN server when set
+	iceServers := []ICEServerConfig{{URLs: []string{stunServer}}}
+	if value := os.Getenv("ICE_SERVERS"); value != "" {
+		if servers, err := ParseICEServers(value); err == nil {
+			iceServers = servers
+		} else {
+			log.Printf("Invalid ICE_SERVERS: %v, using %s", err, stunServer)
+		}
+	}
+
 	return &Config{
-		Port:       port,
-		STUNServer: stunServer,
-		Origins:    []string{"*"},
+		Port:             port,
+		TLSCertFile:      os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:       os.Getenv("TLS_KEY_FILE"),
+		TLSSelfSigned:    envBool("TLS_SELF_SIGNED", false),
+		CertReload:       envMillis("TLS_RELOAD_INTERVAL_MS", 10*time.Second),
+		RedirectPort:     os.Getenv("HTTP_REDIRECT_PORT"),
+		ClientCAFile:     os.Getenv("TLS_CLIENT_CA_FILE"),
+		ICEServers:       iceServers,
+		TURNURLs:         envList("TURN_URLS"),
+		TURNSecret:       os.Getenv("TURN_SECRET"),
+		TURNCredTTL:      envMillis("TURN_CREDENTIAL_TTL_MS", 10*time.Minute),
+		CommandLifetime:  loadCommandLifetime(),
+		SessionGrace:     envMillis("SESSION_GRACE_MS", 30*time.Second),
+		ReplayMessages:   envInt("WS_REPLAY_MAX_MESSAGES", 256),
+		ReplayMaxAge:     envMillis("WS_REPLAY_MAX_AGE_MS", 30*time.Second),
+		Origins:          envList("ALLOWED_ORIGINS"),
+		OriginDevMode:    envBool("ORIGIN_DEV_MODE", false),
+		WatchdogTimeout:  envMillis("WATCHDOG_TIMEOUT_MS", 1500*time.Millisecond),
+		Limits:           loadVelocityLimits(),
+		RoomLimits:       loadRoomLimits(),
+		RampLinearAccel:  envFloat("RAMP_MAX_LINEAR_ACCEL", 0),
+		RampAngularAccel: envFloat("RAMP_MAX_ANGULAR_ACCEL", 0),
+		RampRate:         envFloat("RAMP_RATE_HZ", 50),
+		LeaseTTL:         envMillis("LEASE_TTL_MS", 10*time.Second),
+		LeaseAutoAcquire: envBool("LEASE_AUTO_ACQUIRE", true),
+		EStopRate:        envFloat("ESTOP_RATE_HZ", 10),
+		EStopResetRoles:  envList("ESTOP_RESET_ROLES"),
+		StaleMaxAge:      envMillis("STALE_MAX_AGE_MS", 0),
+		StaleAction:      loadStaleAction(),
+		RejectReordered:  envBool("STALE_REJECT_REORDERED", true),
+		SkewCorrection:   envBool("STALE_SKEW_CORRECTION", false),
+		RateLimits: RateLimitConfig{
+			Messages:     envFloat("RATE_LIMIT_MESSAGES", 200),
+			Bytes:        envFloat("RATE_LIMIT_BYTES", 256*1024),
+			IPMessages:   envFloat("RATE_LIMIT_IP_MESSAGES", 1000),
+			IPBytes:      envFloat("RATE_LIMIT_IP_BYTES", 1024*1024),
+			Connects:     envFloat("RATE_LIMIT_CONNECTS_PER_MIN", 60),
+			ConnectBurst: envInt("RATE_LIMIT_CONNECT_BURST", 10),
+			Action:       loadRateLimitAction(),
+		},
+		MessageCaps: loadMessageCaps(),
+	}
+}
+
+// loadRateLimitAction reads the action for messages over the rate limit.
+func loadRateLimitAction() RateLimitAction {
+	switch action := RateLimitAction(os.Getenv("RATE_LIMIT_ACTION")); action {
+	case RateLimitDrop, RateLimitThrottle, RateLimitDisconnect:
+		return action
+	case "":
+	default:
+		log.Printf("Invalid RATE_LIMIT_ACTION=%q, using %s", action, RateLimitDrop)
+	}
+	return RateLimitDrop
+}
+
+// loadCommandLifetime reads the command channel's max packet lifetime.
+// WebRTC carries it as a 16-bit millisecond count, so larger values are
+// clamped to math.MaxUint16.
+func loadCommandLifetime() time.Duration {
+	lifetime := envMillis("CMD_CHANNEL_MAX_PACKET_LIFETIME_MS", 0)
+	if limit := math.MaxUint16 * time.Millisecond; lifetime > limit {
+		log.Printf("CMD_CHANNEL_MAX_PACKET_LIFETIME_MS=%d exceeds %d, using %d",
+			lifetime.Milliseconds(), math.MaxUint16, math.MaxUint16)
+		return limit
 	}
+	return lifetime
 }
 
-// MessageRouter handles routing of Twist messages between peers.
-type MessageRouter struct {
-	peerManager *PeerManager
-	wsManager   *WSManager // WebSocket manager for cross-protocol routing
-	stats       *RouterStats
+// loadMessageCaps reads payload size caps from MSG_MAX_SIZES, a comma-separated
+// list of "<type name>=<bytes>" entries, e.g. "sensor_msgs/Joy=2048".
+func loadMessageCaps() map[string]int {
+	caps := make(map[string]int)
+	for _, entry := range envList("MSG_MAX_SIZES") {
+		name, value, _ := strings.Cut(entry, "=")
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if _, ok := LookupMessageName(strings.TrimSpace(name)); !ok || err != nil || size < 0 {
+			log.Printf("Invalid MSG_MAX_SIZES entry %q", entry)
+			continue
+		}
+		caps[strings.TrimSpace(name)] = size
+	}
+	return caps
 }
 
-// RouterStats tracks message routing statistics.
-type RouterStats struct {
-	MessagesReceived  uint64
-	MessagesForwarded uint64
-	ParseErrors       uint64
+// loadStaleAction reads the stale command action from the environment.
+func loadStaleAction() StaleAction {
+	switch action := StaleAction(os.Getenv("STALE_ACTION")); action {
+	case StaleActionDrop, StaleActionZero:
+		return action
+	case "":
+	default:
+		log.Printf("Invalid STALE_ACTION=%q, using %s", action, StaleActionDrop)
+	}
+	return StaleActionDrop
 }
 
-// NewMessageRouter creates a new message router.
-func NewMessageRouter(pm *PeerManager) *MessageRouter {
-	return &MessageRouter{
-		peerManager: pm,
-		stats:       &RouterStats{},
+// envList reads a comma-separated list from the environment.
+// Empty entries are skipped; returns nil if the variable is unset.
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
 }
 
-// SetWSManager sets the WebSocket manager for cross-protocol routing.
-func (mr *MessageRouter) SetWSManager(wsm *WSManager) {
-	mr.wsManager = wsm
+// loadVelocityLimits loads the command velocity envelope from environment variables.
+// Defaults match the maximum speeds offered by the web client.
+func loadVelocityLimits() VelocityLimits {
+	limits := VelocityLimits{
+		MaxLinear:  Vector3{X: 5, Y: 5, Z: 5},
+		MaxAngular: Vector3{X: 3, Y: 3, Z: 3},
+		LockedAxes: map[string]bool{},
+		Action:     LimitActionClamp,
+	}
+
+	if value := os.Getenv("LIMIT_MAX_LINEAR"); value != "" {
+		if v, err := parseLimitVector(value); err == nil {
+			limits.MaxLinear = v
+		} else {
+			log.Printf("Invalid LIMIT_MAX_LINEAR=%q: %v", value, err)
+		}
+	}
+	if value := os.Getenv("LIMIT_MAX_ANGULAR"); value != "" {
+		if v, err := parseLimitVector(value); err == nil {
+			limits.MaxAngular = v
+		} else {
+			log.Printf("Invalid LIMIT_MAX_ANGULAR=%q: %v", value, err)
+		}
+	}
+	limits.MaxLinearSpeed = envFloat("LIMIT_MAX_LINEAR_SPEED", 0)
+	limits.MaxAngularSpeed = envFloat("LIMIT_MAX_ANGULAR_SPEED", 0)
+
+	if value := os.Getenv("LIMIT_LOCKED_AXES"); value != "" {
+		if locked, err := parseLockedAxes(value); err == nil {
+			limits.LockedAxes = locked
+		} else {
+			log.Printf("Invalid LIMIT_LOCKED_AXES=%q: %v", value, err)
+		}
+	}
+
+	switch action := LimitAction(os.Getenv("LIMIT_ACTION")); action {
+	case "":
+	case LimitActionClamp, LimitActionDrop:
+		limits.Action = action
+	default:
+		log.Printf("Invalid LIMIT_ACTION=%q, using %s", action, limits.Action)
+	}
+
+	return limits
 }
 
-// HandleMessage processes an incoming DataChannel message.
-// Routes Twist messages from web clients to Python clients and vice versa.
-// Also bridges to WebSocket clients.
-func (mr *MessageRouter) HandleMessage(from *Peer, data []byte) {
-	mr.stats.MessagesReceived++
+// loadRoomLimits loads per-room velocity envelopes from LIMIT_ROOMS.
+func loadRoomLimits() LimitsByRoom {
+	value := os.Getenv("LIMIT_ROOMS")
+	if value == "" {
+		return nil
+	}
 
-	// Attempt to decode as Twist message
-	twist, err := DecodeTwist(data)
+	rooms, err := ParseRoomLimits(value)
 	if err != nil {
-		// Not a valid Twist message - could be a control message
-		log.Printf("[Router] Non-Twist message from %s (%d bytes)", from.ID, len(data))
-		mr.stats.ParseErrors++
-		return
+		log.Printf("Invalid LIMIT_ROOMS: %v", err)
+		return nil
 	}
+	return rooms
+}
 
-	if !twist.IsZero() {
-		log.Printf("[Router] Twist from %s: %s", from.ID, twist.String())
+// envFloat reads a non-negative float from the environment.
+// Returns def if the variable is unset or invalid.
+func envFloat(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
 	}
 
-	// Route based on source peer type
-	switch from.Type {
-	case PeerTypeWeb:
-		// Forward to all Python clients (WebRTC)
-		sent := mr.peerManager.BroadcastToType(PeerTypePython, data)
-		mr.stats.MessagesForwarded += uint64(sent)
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		log.Printf("Invalid %s=%q, using default %g", key, value, def)
+		return def
+	}
+	return f
+}
 
-		// Also forward to Python WebSocket clients
-		if mr.wsManager != nil {
-			wsSent := mr.wsManager.BroadcastToType("python", data)
-			mr.stats.MessagesForwarded += uint64(wsSent)
-			sent += wsSent
-		}
+// envBool reads a boolean from the environment.
+// Returns def if the variable is unset or invalid.
+func envBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
 
-		if sent > 0 {
-			log.Printf("[Router] Forwarded to %d Python client(s)", sent)
-		}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %t", key, value, def)
+		return def
+	}
+	return b
+}
+
+// envInt reads a non-negative integer from the environment.
+// Returns def if the variable is unset or invalid.
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
 
-	case PeerTypePython:
-		// Forward to all web clients (WebRTC)
-		sent := mr.peerManager.BroadcastToType(PeerTypeWeb, data)
-		mr.stats.MessagesForwarded += uint64(sent)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s=%q, using default %d", key, value, def)
+		return def
+	}
+	return n
+}
 
-		// Also forward to web WebSocket clients
-		if mr.wsManager != nil {
-			wsSent := mr.wsManager.BroadcastToType("web", data)
-			mr.stats.MessagesForwarded += uint64(wsSent)
-			sent += wsSent
-		}
+// envMillis reads a duration in milliseconds from the environment.
+// Returns def if the variable is unset or not a valid non-negative integer.
+func envMillis(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
 
-		if sent > 0 {
-			log.Printf("[Router] Forwarded to %d web client(s)", sent)
-		}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		log.Printf("Invalid %s=%q, using default %s", key, value, def)
+		return def
 	}
+	return time.Duration(ms) * time.Millisecond
 }
 
-// GetStats returns current routing statistics.
-func (mr *MessageRouter) GetStats() RouterStats {
-	return *mr.stats
+// StatsResponse is returned by the /stats endpoint.
+type StatsResponse struct {
+	RouterStats
+	WSSignaling  int `json:"ws_signaling"`   // Connected signaling WebSocket clients
+	WSDataWeb    int `json:"ws_data_web"`    // Connected web data WebSocket clients
+	WSDataPython int `json:"ws_data_python"` // Connected Python data WebSocket clients
+
+	Sequence map[string]SequenceStats `json:"sequence"` // Per-client envelope sequence counters
+	Media    SFUStats                 `json:"media"`    // Media forwarding counters
+
+	OriginRejected uint64 `json:"origin_rejected"` // Requests refused for their Origin header
+
+	RateLimit RateLimitStats `json:"rate_limit"` // Rate limit violations
 }
 
 func main() {
@@ -136,28 +338,143 @@ func main() {
 
 	// Load configuration
 	config := loadConfig()
-	log.Printf("Configuration: Port=%s, STUN=%s", config.Port, config.STUNServer)
+	log.Printf("Configuration: Port=%s, ICE servers=%d, Watchdog=%s", config.Port, len(config.ICEServers), config.WatchdogTimeout)
+	log.Printf("Velocity limits: %s", config.Limits.String())
+
+	// Refuse to start with a broken auth setup rather than run open
+	auth, err := loadAuthenticator()
+	if err != nil {
+		log.Fatalf("Authentication: %v", err)
+	}
+	if auth == nil {
+		log.Println("Authentication disabled: anyone who can reach the relay can drive the robot")
+	} else {
+		log.Printf("Authentication: %s", os.Getenv("AUTH_MODE"))
+	}
+
+	// A typo in the allowlist must not silently open or close the relay
+	origins, err := NewOriginPolicy(config.Origins, config.OriginDevMode)
+	if err != nil {
+		log.Fatalf("ALLOWED_ORIGINS: %v", err)
+	}
+	log.Printf("Allowed origins: %s", origins)
+
+	certs, err := loadCertificates(config)
+	if err != nil {
+		log.Fatalf("TLS: %v", err)
+	}
+
+	// Robots authenticate with client certificates signed by this CA
+	var clientCAs *x509.CertPool
+	if config.ClientCAFile != "" {
+		if certs == nil {
+			log.Fatal("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE or TLS_SELF_SIGNED")
+		}
+		if clientCAs, err = loadClientCAs(config.ClientCAFile); err != nil {
+			log.Fatalf("TLS_CLIENT_CA_FILE: %v", err)
+		}
+		log.Printf("Robots must connect with client certificates from %s", config.ClientCAFile)
+	}
+
+	// Apply size cap overrides before any message is routed
+	for name, size := range config.MessageCaps {
+		info, _ := LookupMessageName(name)
+		info.MaxSize = size
+	}
+
+	limiter := NewRateLimiter(config.RateLimits)
+	log.Printf("Rate limits: %s", limiter)
 
 	// Create WebRTC configuration
+	iceServers := NewICEServers(config.ICEServers, config.TURNURLs, config.TURNSecret, config.TURNCredTTL)
 	webrtcConfig := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{config.STUNServer}},
-		},
+		ICEServers: iceServers.WebRTC(),
+	}
+	if config.TURNSecret != "" && len(config.TURNURLs) == 0 {
+		log.Println("TURN_SECRET is set but TURN_URLS is empty; clients get no TURN servers")
 	}
 
 	// Initialize peer manager
 	peerManager := NewPeerManager(webrtcConfig)
+	peerManager.SetCommandLifetime(config.CommandLifetime)
+	peerManager.SetGracePeriod(config.SessionGrace)
+	peerManager.SetRateLimiter(limiter)
 	defer peerManager.Close()
 
 	// Initialize message router
 	router := NewMessageRouter(peerManager)
+	router.SetLimits(config.Limits)
+	for room, limits := range config.RoomLimits {
+		router.SetRoomLimits(room, &limits)
+		log.Printf("Velocity limits for %s: %s", room, limits.String())
+	}
 	peerManager.SetMessageHandler(router.HandleMessage)
+	if config.WatchdogTimeout > 0 {
+		router.StartWatchdog(config.WatchdogTimeout)
+	}
+	if (config.RampLinearAccel > 0 || config.RampAngularAccel > 0) && config.RampRate > 0 {
+		// The ramp only refreshes commands that arrive, so it relies on the
+		// watchdog to stop a robot whose operator went silent mid-motion
+		if config.WatchdogTimeout <= 0 {
+			log.Fatal("RAMP_MAX_LINEAR_ACCEL/RAMP_MAX_ANGULAR_ACCEL require WATCHDOG_TIMEOUT_MS > 0")
+		}
+		router.EnableRamp(config.RampLinearAccel, config.RampAngularAccel, config.RampRate)
+	}
+	if config.LeaseTTL > 0 {
+		router.EnableLease(config.LeaseTTL, config.LeaseAutoAcquire)
+	}
+	if config.EStopRate > 0 {
+		// With authentication, only admins reset the e-stop unless configured otherwise
+		resetRoles := config.EStopResetRoles
+		if auth != nil && len(resetRoles) == 0 {
+			resetRoles = []string{string(RoleAdmin)}
+		}
+		router.EnableEStop(config.EStopRate, resetRoles)
+	}
+	if config.StaleMaxAge > 0 || config.RejectReordered {
+		router.SetStaleFilter(NewStaleFilter(config.StaleMaxAge, config.StaleAction,
+			config.RejectReordered, config.SkewCorrection))
+	}
+	defer router.Close()
+
+	// Forward robot media tracks to the operators in the same room
+	sfu := NewSFU(peerManager)
+	sfu.SetTalkPolicy(router.IsOperator)
+	peerManager.SetTrackHandler(sfu.HandleTrack)
+
+	peerManager.SetRemoveHandler(func(peer *Peer) {
+		sfu.RemovePeer(peer)
+		router.HandleDisconnect(peer.ID, peer.Room)
+	})
+	peerManager.SetSuspendHandler(func(peer *Peer) {
+		router.HandleSuspend(peer.ID, peer.Room, config.SessionGrace)
+	})
+	peerManager.SetOpenHandler(func(peer *Peer) {
+		router.HandleJoin(peer.ID, peer.Type, peer.Room)
+		sfu.Subscribe(peer)
+	})
 
 	// Initialize signaling handler
 	signaling := NewSignalingHandler(peerManager)
+	signaling.SetRouter(router)
+	signaling.SetICEServers(iceServers)
+	signaling.SetSFU(sfu)
+	signaling.SetAuthenticator(auth)
+	signaling.SetOriginPolicy(origins)
+	signaling.SetRateLimiter(limiter)
+	if clientCAs != nil {
+		signaling.RequireRobotCertificates()
+	}
 
 	// Initialize WebSocket manager with router for cross-protocol bridging
 	wsManager := NewWSManager(router, peerManager)
+	wsManager.SetSessionReplay(config.SessionGrace, config.ReplayMessages, config.ReplayMaxAge)
+	wsManager.SetAuthenticator(auth)
+	wsManager.SetOriginPolicy(origins)
+	wsManager.SetRateLimiter(limiter)
+	if clientCAs != nil {
+		wsManager.RequireRobotCertificates()
+	}
 
 	// Connect WSManager to router for bidirectional bridging
 	router.SetWSManager(wsManager)
@@ -171,14 +488,23 @@ func main() {
 	mux.HandleFunc("/ws/data", wsManager.HandleDataWS)
 
 	// Add stats endpoint
-	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		stats := router.GetStats()
+	mux.HandleFunc("/stats", requireAuth(auth, func(w http.ResponseWriter, r *http.Request) {
 		wsWeb, wsPython := wsManager.GetDataClientsByType()
+		resp := StatsResponse{
+			RouterStats:  router.GetStats(),
+			WSSignaling:  wsManager.GetSignalingClientCount(),
+			WSDataWeb:    wsWeb,
+			WSDataPython: wsPython,
+			Sequence:     router.SequenceStats(),
+			Media:        sfu.GetStats(),
+
+			OriginRejected: origins.Rejected(),
+
+			RateLimit: limiter.Stats(),
+		}
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"received":%d,"forwarded":%d,"errors":%d,"ws_signaling":%d,"ws_data_web":%d,"ws_data_python":%d}`,
-			stats.MessagesReceived, stats.MessagesForwarded, stats.ParseErrors,
-			wsManager.GetSignalingClientCount(), wsWeb, wsPython)
-	})
+		json.NewEncoder(w).Encode(resp)
+	}))
 
 	// Serve web client files from ../web-client directory
 	webClientDir := "../web-client"
@@ -197,7 +523,7 @@ func main() {
 		log.Printf("  Serving web client from %s", webClientDir)
 	} else {
 		log.Printf(" Web client directory not found at %s", webClientDir)
-		log.Println("   Open web-client/index.html directly in browser")
+		log.Println("   Open web-client/index.html directly in browser (needs ORIGIN_DEV_MODE=true)")
 	}
 
 	// Create HTTP server with timeouts
@@ -209,6 +535,43 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Serve HTTPS/WSS when a certificate is configured
+	scheme, wsScheme := "http", "ws"
+	var redirect *http.Server
+	if certs != nil {
+		scheme, wsScheme = "https", "wss"
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		if clientCAs != nil {
+			// Browsers connect without a certificate; robots present one
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			server.TLSConfig.ClientCAs = clientCAs
+		}
+		if config.CertReload > 0 {
+			certs.Start(config.CertReload)
+			defer certs.Stop()
+		}
+
+		if config.RedirectPort != "" {
+			redirect = &http.Server{
+				Addr:         ":" + config.RedirectPort,
+				Handler:      redirectHandler(config.Port),
+				ReadTimeout:  10 * time.Second,
+				WriteTimeout: 10 * time.Second,
+			}
+			go func() {
+				if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+					log.Printf("[TLS] Redirect listener error: %v", err)
+				}
+			}()
+			log.Printf("  Redirecting http://localhost:%s to HTTPS", config.RedirectPort)
+		}
+	} else if config.RedirectPort != "" {
+		log.Println("HTTP_REDIRECT_PORT is set but TLS is disabled; not redirecting")
+	}
+
 	// Graceful shutdown handling
 	done := make(chan bool, 1)
 	quit := make(chan os.Signal, 1)
@@ -218,34 +581,53 @@ func main() {
 		<-quit
 		log.Println("\nShutting down server...")
 
+		// Stop injecting commands before tearing down peers
+		router.Close()
+
 		// Close all peer connections
 		peerManager.Close()
 
 		// Shutdown HTTP server
 		server.Close()
+		if redirect != nil {
+			redirect.Close()
+		}
 		done <- true
 	}()
 
 	// Start server
-	log.Printf("  Server starting on http://localhost:%s", config.Port)
+	log.Printf("  Server starting on %s://localhost:%s", scheme, config.Port)
 	log.Println("")
 	log.Println("Web Interface:")
-	log.Printf("  http://localhost:%s/          - Robot Control UI", config.Port)
+	log.Printf("  %s://localhost:%s/          - Robot Control UI", scheme, config.Port)
 	log.Println("")
 	log.Println("HTTP Endpoints:")
 	log.Println("  POST /offer  - WebRTC signaling")
+	log.Println("  POST /connect - Relay-initiated offer (answer via POST /answer)")
 	log.Println("  POST /ice    - ICE candidates")
+	log.Println("  GET  /ice-servers - STUN/TURN servers for clients")
+	log.Println("  GET  /control - Control lease holder (POST to force handover)")
+	log.Println("  POST /estop  - Engage emergency stop (POST /estop/reset to clear)")
+	log.Println("  POST /kick   - Disconnect a client (admin)")
+	log.Println("  GET  /config - Velocity limits (POST to change, admin)")
+	log.Println("  GET  /robots - Online robots and operator counts")
 	log.Println("  GET  /status - Server status")
 	log.Println("  GET  /stats  - Message statistics")
 	log.Println("  GET  /health - Health check")
 	log.Println("")
 	log.Println("WebSocket Endpoints:")
-	log.Printf("  ws://localhost:%s/ws/signaling - Signaling + ping/pong keepalive", config.Port)
-	log.Printf("  ws://localhost:%s/ws/data      - Data transfer (Twist messages)", config.Port)
+	log.Printf("  %s://localhost:%s/ws/signaling - Signaling + ping/pong keepalive", wsScheme, config.Port)
+	log.Printf("  %s://localhost:%s/ws/data      - Data transfer (Twist messages)", wsScheme, config.Port)
 	log.Println("")
 	log.Println("Press Ctrl+C to stop")
 
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	if certs != nil {
+		// Certificates come from TLSConfig.GetCertificate
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 
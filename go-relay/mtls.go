@@ -0,0 +1,75 @@
+// Package main provides client certificate (mutual TLS) identities for robots.
+//
+// With TLS_CLIENT_CA_FILE set, the relay asks TLS clients for a certificate
+// and verifies it against that CA. Browsers don't need one; robots do: a
+// Python client (/ws/data?type=python, /ws/signaling?type=python, or an
+// /offer or /connect with peerType "python") is only accepted over a
+// connection with a verified certificate, for the room the certificate names.
+//
+// The certificate subject maps to the robot:
+//   - CN - robot ID, the identity's subject
+//   - OU - room the robot may join (default: the robot ID)
+//
+// A verified certificate stands in for a bearer token on every endpoint, with
+// the robot role.
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// loadClientCAs reads the PEM CA certificates that sign robot certificates.
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates", file)
+	}
+	return pool, nil
+}
+
+// certificateIdentity returns the robot identity of a request that arrived
+// over TLS with a verified client certificate, or nil.
+func certificateIdentity(r *http.Request) *Identity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName == "" {
+		return nil
+	}
+	room := subject.CommonName
+	if len(subject.OrganizationalUnit) > 0 {
+		room = subject.OrganizationalUnit[0]
+	}
+	if _, err := ParseRoom(room); err != nil {
+		return nil
+	}
+
+	return &Identity{
+		Subject: subject.CommonName,
+		Role:    RoleRobot,
+		Room:    room,
+		Cert:    true,
+	}
+}
+
+// checkRobotCertificate reports why an identity may not be the robot in
+// room when robots must use client certificates, or nil if it may.
+func checkRobotCertificate(identity *Identity, room string) error {
+	if identity == nil || !identity.Cert {
+		return errors.New("robots must connect with a verified client certificate")
+	}
+	if identity.Room != room {
+		return fmt.Errorf("certificate of %s is for room %q", identity.Subject, identity.Room)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package main provides the origin policy for browser requests.
+//
+// Browsers attach an Origin header to cross-site requests and to every
+// WebSocket handshake. Without a check, any page a logged-in operator visits
+// could open /ws/data and drive the robot with the operator's credentials.
+// The policy decides which origins may use the relay; it is applied to CORS
+// preflights, the signaling endpoints and both WebSocket upgrades.
+//
+// Allowed origin patterns:
+//   - https://app.example.com  - exact origin (scheme, host and port)
+//   - app.example.com          - host on any scheme
+//   - *.example.com            - any subdomain (not example.com itself),
+//     optionally with a scheme: https://*.example.com
+//   - *                        - any origin
+//
+// Requests without an Origin header (Python clients, curl) and same-origin
+// requests (the web client served by the relay) are always allowed. Dev mode
+// also allows localhost and pages opened from file:// (Origin "null").
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// originPattern is one parsed entry of the allowlist.
+type originPattern struct {
+	scheme   string // "" matches any scheme
+	host     string // Host, with port if one was given
+	wildcard bool   // Match subdomains of host
+}
+
+// OriginPolicy decides which browser origins may use the relay.
+type OriginPolicy struct {
+	allowAll bool
+	dev      bool
+	patterns []originPattern
+	rejected atomic.Uint64
+}
+
+// NewOriginPolicy parses an origin allowlist.
+// An empty list allows only same-origin requests (plus localhost in dev mode).
+func NewOriginPolicy(origins []string, dev bool) (*OriginPolicy, error) {
+	p := &OriginPolicy{dev: dev}
+	for _, origin := range origins {
+		if origin == "*" {
+			p.allowAll = true
+			continue
+		}
+		pattern, err := parseOriginPattern(origin)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, pattern)
+	}
+	return p, nil
+}
+
+// parseOriginPattern parses "[scheme://][*.]host[:port]".
+func parseOriginPattern(origin string) (originPattern, error) {
+	var pattern originPattern
+	rest := strings.ToLower(strings.TrimSuffix(origin, "/"))
+	if scheme, host, ok := strings.Cut(rest, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return pattern, fmt.Errorf("origin %q: scheme must be http or https", origin)
+		}
+		pattern.scheme, rest = scheme, host
+	}
+	if strings.HasPrefix(rest, "*.") {
+		pattern.wildcard, rest = true, rest[2:]
+	}
+	if rest == "" || strings.ContainsAny(rest, "/*?#@ ") {
+		return pattern, fmt.Errorf("origin %q: expected [scheme://][*.]host[:port]", origin)
+	}
+	pattern.host = rest
+	return pattern, nil
+}
+
+// matches reports whether a parsed Origin header fits the pattern.
+func (p originPattern) matches(u *url.URL) bool {
+	if p.scheme != "" && p.scheme != u.Scheme {
+		return false
+	}
+
+	// Compare ports only if the pattern names one
+	host := strings.ToLower(u.Hostname())
+	want := p.host
+	if _, _, err := net.SplitHostPort(p.host); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+want)
+	}
+	return host == want
+}
+
+// Allowed reports whether the request's origin may use the relay.
+func (p *OriginPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.allowAll {
+		return true
+	}
+	if origin == "null" {
+		return p.dev
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if p.dev && isLoopbackHost(u.Hostname()) {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check is Allowed, logging and counting rejections.
+func (p *OriginPolicy) Check(r *http.Request, tag string) bool {
+	if p == nil || p.Allowed(r) {
+		return true
+	}
+	p.rejected.Add(1)
+	log.Printf("[%s] Rejected origin %q for %s %s from %s", tag, r.Header.Get("Origin"), r.Method, r.URL.Path, r.RemoteAddr)
+	return false
+}
+
+// Rejected returns the number of requests refused for their origin.
+func (p *OriginPolicy) Rejected() uint64 {
+	if p == nil {
+		return 0
+	}
+	return p.rejected.Load()
+}
+
+// String describes the policy for the startup log.
+func (p *OriginPolicy) String() string {
+	if p.allowAll {
+		return "any origin"
+	}
+	desc := "same origin"
+	for _, pattern := range p.patterns {
+		entry := pattern.host
+		if pattern.wildcard {
+			entry = "*." + entry
+		}
+		if pattern.scheme != "" {
+			entry = pattern.scheme + "://" + entry
+		}
+		desc += ", " + entry
+	}
+	if p.dev {
+		desc += ", localhost (dev)"
+	}
+	return desc
+}
+
+// isLoopbackHost reports whether host names the local machine.
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
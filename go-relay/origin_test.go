@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicyAllowed(t *testing.T) {
+	allowlist := []string{"https://app.example.com", "ops.example.com", "*.robots.example.com", "http://lab.local:8080"}
+
+	tests := []struct {
+		name    string
+		origins []string
+		dev     bool
+		origin  string
+		want    bool
+	}{
+		{"no origin", allowlist, false, "", true},
+		{"same origin", nil, false, "https://relay.example.com", true},
+		{"exact", allowlist, false, "https://app.example.com", true},
+		{"exact, wrong scheme", allowlist, false, "http://app.example.com", false},
+		{"exact, case", allowlist, false, "https://APP.example.com", true},
+		{"any scheme", allowlist, false, "http://ops.example.com", true},
+		{"subdomain", allowlist, false, "https://arm.robots.example.com", true},
+		{"wildcard base", allowlist, false, "https://robots.example.com", false},
+		{"suffix lookalike", allowlist, false, "https://evilrobots.example.com", false},
+		{"port matches", allowlist, false, "http://lab.local:8080", true},
+		{"port differs", allowlist, false, "http://lab.local:9090", false},
+		{"not listed", allowlist, false, "https://evil.com", false},
+		{"listed host as subdomain", allowlist, false, "https://app.example.com.evil.com", false},
+		{"malformed", allowlist, false, "://", false},
+		{"null", allowlist, false, "null", false},
+		{"null, dev", nil, true, "null", true},
+		{"localhost", nil, false, "http://localhost:3000", false},
+		{"localhost, dev", nil, true, "http://localhost:3000", true},
+		{"loopback ip, dev", nil, true, "http://127.0.0.1:5173", true},
+		{"any", []string{"*"}, false, "https://evil.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewOriginPolicy(tt.origins, tt.dev)
+			if err != nil {
+				t.Fatalf("NewOriginPolicy() error = %v", err)
+			}
+
+			r := httptest.NewRequest("GET", "https://relay.example.com/ws/data", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := p.Allowed(r); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginPolicyCheck(t *testing.T) {
+	p, err := NewOriginPolicy([]string{"https://app.example.com"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, origin := range []string{"https://app.example.com", "https://evil.com", "https://evil.com"} {
+		r := httptest.NewRequest("GET", "https://relay.example.com/offer", nil)
+		r.Header.Set("Origin", origin)
+		p.Check(r, "Test")
+	}
+	if got := p.Rejected(); got != 2 {
+		t.Errorf("Rejected() = %d, want 2", got)
+	}
+
+	// A nil policy allows everything
+	var none *OriginPolicy
+	r := httptest.NewRequest("GET", "https://relay.example.com/offer", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	if !none.Check(r, "Test") || none.Rejected() != 0 {
+		t.Errorf("nil policy rejected a request")
+	}
+}
+
+func TestNewOriginPolicyRejectsInvalidPatterns(t *testing.T) {
+	for _, origin := range []string{"ftp://app.example.com", "https://", "*.", "app.example.com/path", "https://*", "user@app.example.com"} {
+		if _, err := NewOriginPolicy([]string{origin}, false); err == nil {
+			t.Errorf("NewOriginPolicy(%q) succeeded, want an error", origin)
+		}
+	}
+}
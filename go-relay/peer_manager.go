@@ -1,15 +1,37 @@
 // Package main provides WebRTC peer connection management.
 //
 // PeerManager handles creation, tracking, and cleanup of WebRTC peer connections.
-// It maintains a thread-safe registry of all connected peers and their data channels.
+// It maintains a thread-safe registry of all connected peers and their data channels,
+// partitioned by room so broadcasts never leave the sender's room.
+//
+// A peer may open several labeled data channels. The first channel other than
+// the command channel is the primary channel: it is reliable and ordered and
+// carries control, e-stop and telemetry messages. The optional command channel
+// ("cmd") is unordered and never retransmits, so a lost motion command is
+// replaced by the next one instead of stalling the ones behind it.
+//
+// Peers can also carry media tracks. Once connected, the relay renegotiates
+// the session by sending a new SDP offer over the primary channel as
+// {"type": "offer", "sdp": "..."}; the peer replies {"type": "answer", "sdp": "..."}.
+//
+// A peer whose connection fails is suspended rather than removed. It keeps
+// its ID, type, room and control lease for a grace period, during which the
+// client can resume it by sending a new (ICE restart) offer together with the
+// peer's session token. Peers that are not resumed in time are removed.
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -23,24 +45,91 @@ const (
 	PeerTypePython PeerType = "python"
 )
 
+const (
+	// ChannelReliable is the default label of the primary data channel.
+	ChannelReliable = "twist"
+	// ChannelCommand is the label of the low-latency motion command channel.
+	ChannelCommand = "cmd"
+)
+
 // Peer represents a connected WebRTC peer with its associated resources.
 type Peer struct {
 	ID             string                    // Unique identifier
 	Type           PeerType                  // web or python
+	Room           string                    // Room (robot) the peer joined
+	Token          string                    // Session token for resuming the peer
+	Identity       *Identity                 // Authenticated client (nil without authentication)
+	Addr           string                    // IP address the peer signaled from
 	Connection     *webrtc.PeerConnection    // WebRTC peer connection
-	DataChannel    *webrtc.DataChannel       // Primary data channel for Twist messages
 	mu             sync.RWMutex              // Protects concurrent access
 	OnTwistMessage func(twist *TwistMessage) // Callback for received Twist messages
+
+	channels map[string]*webrtc.DataChannel // Data channels indexed by label
+	primary  *webrtc.DataChannel            // Reliable channel for control and telemetry
+	joined   bool                           // Primary channel has opened
+
+	// Renegotiation state: an offer is awaiting its answer, and another
+	// offer is due once it arrives.
+	negotiating bool
+	renegotiate bool
+
+	grace *time.Timer // Removes the peer unless it is resumed (nil while healthy)
+
+	// Local ICE candidates for trickle ICE. Without a candidate handler they
+	// are queued until the client polls for them.
+	localCandidates []webrtc.ICECandidateInit
+	gatheringDone   bool
+	onCandidate     func(candidate webrtc.ICECandidateInit)
+}
+
+// Channel returns the peer's data channel with the given label, or nil.
+func (p *Peer) Channel(label string) *webrtc.DataChannel {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.channels[label]
+}
+
+// Labels returns the labels of the peer's data channels in sorted order.
+func (p *Peer) Labels() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	labels := make([]string, 0, len(p.channels))
+	for label := range p.channels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
 }
 
+// SetCandidateHandler delivers the peer's local ICE candidates to handler as
+// they are gathered, instead of queueing them for polling.
+func (p *Peer) SetCandidateHandler(handler func(candidate webrtc.ICECandidateInit)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onCandidate = handler
+}
+
+// TrackHandler handles a media track received from a peer.
+type TrackHandler func(peer *Peer, track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+
 // PeerManager manages all connected WebRTC peers.
 // Thread-safe for concurrent access from multiple goroutines.
 type PeerManager struct {
-	peers      map[string]*Peer // Active peers indexed by ID
-	mu         sync.RWMutex     // Protects peers map
-	webrtcAPI  *webrtc.API      // WebRTC API instance
-	config     webrtc.Configuration
-	onMessage  func(from *Peer, data []byte) // Global message handler
+	peers     map[string]*Peer            // Active peers indexed by ID
+	rooms     map[string]map[string]*Peer // Active peers indexed by room, then ID
+	mu        sync.RWMutex                // Protects peers and rooms maps
+	webrtcAPI *webrtc.API                 // WebRTC API instance
+	config    webrtc.Configuration
+	onMessage func(from *Peer, data []byte) // Global message handler
+	onRemove  func(peer *Peer)              // Called after a peer is removed
+	onOpen    func(peer *Peer)              // Called when a peer's primary DataChannel opens
+	onTrack   TrackHandler                  // Called for each incoming media track
+	onSuspend func(peer *Peer)              // Called when a peer enters its grace period
+	limiter   *RateLimiter                  // Rate limits for DataChannel messages (nil disables)
+
+	commandLifetime time.Duration // Max packet lifetime on the command channel (0 = no retransmits)
+	gracePeriod     time.Duration // How long a failed peer may be resumed (0 = remove at once)
 }
 
 // NewPeerManager creates a new PeerManager with the given WebRTC configuration.
@@ -54,11 +143,29 @@ type PeerManager struct {
 func NewPeerManager(config webrtc.Configuration) *PeerManager {
 	return &PeerManager{
 		peers:     make(map[string]*Peer),
-		webrtcAPI: webrtc.NewAPI(),
+		rooms:     make(map[string]map[string]*Peer),
+		webrtcAPI: newMediaAPI(),
 		config:    config,
 	}
 }
 
+// newMediaAPI creates a WebRTC API with the default codecs and the default
+// RTCP interceptors (NACK, reports, TWCC), as needed to forward media.
+func newMediaAPI() *webrtc.API {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		log.Printf("[PeerManager] Failed to register codecs, media disabled: %v", err)
+		return webrtc.NewAPI()
+	}
+
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, registry); err != nil {
+		log.Printf("[PeerManager] Failed to register interceptors: %v", err)
+	}
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithInterceptorRegistry(registry))
+}
+
 // SetMessageHandler sets the global callback for all incoming DataChannel messages.
 // The callback receives the source peer and raw message data.
 func (pm *PeerManager) SetMessageHandler(handler func(from *Peer, data []byte)) {
@@ -67,16 +174,79 @@ func (pm *PeerManager) SetMessageHandler(handler func(from *Peer, data []byte))
 	pm.onMessage = handler
 }
 
+// SetRateLimiter sets the rate limits applied to DataChannel messages.
+func (pm *PeerManager) SetRateLimiter(limiter *RateLimiter) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.limiter = limiter
+}
+
+// SetOpenHandler sets the callback invoked when a peer's DataChannel opens.
+func (pm *PeerManager) SetOpenHandler(handler func(peer *Peer)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.onOpen = handler
+}
+
+// SetCommandLifetime sets how long the command channel may retransmit a
+// message before dropping it. Zero disables retransmission entirely; lifetimes
+// beyond 65535ms are clamped when a channel is opened.
+func (pm *PeerManager) SetCommandLifetime(lifetime time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.commandLifetime = lifetime
+}
+
+// SetTrackHandler sets the callback invoked for each media track a peer sends.
+// The callback runs on its own goroutine and may block while reading the track.
+func (pm *PeerManager) SetTrackHandler(handler TrackHandler) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.onTrack = handler
+}
+
+// SetGracePeriod sets how long a failed peer is kept for resumption.
+// Zero removes failed peers immediately.
+func (pm *PeerManager) SetGracePeriod(grace time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.gracePeriod = grace
+}
+
+// GracePeriod returns how long a failed peer is kept for resumption.
+func (pm *PeerManager) GracePeriod() time.Duration {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.gracePeriod
+}
+
+// SetSuspendHandler sets the callback invoked when a peer is suspended.
+func (pm *PeerManager) SetSuspendHandler(handler func(peer *Peer)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.onSuspend = handler
+}
+
+// SetRemoveHandler sets the callback invoked after a peer has been removed.
+func (pm *PeerManager) SetRemoveHandler(handler func(peer *Peer)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.onRemove = handler
+}
+
 // CreatePeer creates a new WebRTC peer connection and registers it.
 // Returns the peer ID and any error encountered.
 //
 // Parameters:
 //   - peerType: The type of peer (web or python)
+//   - room: The room (robot) the peer joins
+//   - identity: The authenticated client, or nil without authentication
+//   - addr: The client's IP address, for per-IP rate limits
 //
 // Returns:
 //   - *Peer: The created peer instance
 //   - error: Any error during creation
-func (pm *PeerManager) CreatePeer(peerType PeerType) (*Peer, error) {
+func (pm *PeerManager) CreatePeer(peerType PeerType, room string, identity *Identity, addr string) (*Peer, error) {
 	// Create new peer connection
 	pc, err := pm.webrtcAPI.NewPeerConnection(pm.config)
 	if err != nil {
@@ -89,6 +259,10 @@ func (pm *PeerManager) CreatePeer(peerType PeerType) (*Peer, error) {
 	peer := &Peer{
 		ID:         peerID,
 		Type:       peerType,
+		Room:       room,
+		Token:      uuid.New().String(),
+		Identity:   identity,
+		Addr:       addr,
 		Connection: pc,
 	}
 
@@ -97,16 +271,38 @@ func (pm *PeerManager) CreatePeer(peerType PeerType) (*Peer, error) {
 		log.Printf("[Peer %s] Connection state: %s", peerID, state.String())
 
 		switch state {
-		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+		case webrtc.PeerConnectionStateFailed:
+			pm.Suspend(peerID)
+		case webrtc.PeerConnectionStateClosed:
 			pm.RemovePeer(peerID)
+		case webrtc.PeerConnectionStateConnected:
+			pm.stopGrace(peer)
 		}
 	})
 
+	// Collect local ICE candidates for trickle ICE
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		pm.handleLocalCandidate(peer, candidate)
+	})
+
 	// Set up ICE connection state handler
 	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
 		log.Printf("[Peer %s] ICE state: %s", peerID, state.String())
 	})
 
+	// Hand incoming media tracks to the track handler
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		log.Printf("[Peer %s] Track received: %s %s (%s)", peerID, track.Kind(), track.ID(), track.Codec().MimeType)
+
+		pm.mu.RLock()
+		handler := pm.onTrack
+		pm.mu.RUnlock()
+
+		if handler != nil {
+			handler(peer, track, receiver)
+		}
+	})
+
 	// Set up data channel handler for incoming channels
 	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
 		log.Printf("[Peer %s] DataChannel received: %s", peerID, dc.Label())
@@ -116,20 +312,271 @@ func (pm *PeerManager) CreatePeer(peerType PeerType) (*Peer, error) {
 	// Register peer
 	pm.mu.Lock()
 	pm.peers[peerID] = peer
+	if pm.rooms[room] == nil {
+		pm.rooms[room] = make(map[string]*Peer)
+	}
+	pm.rooms[room][peerID] = peer
 	pm.mu.Unlock()
 
-	log.Printf("[PeerManager] Created peer %s (type: %s)", peerID, peerType)
+	log.Printf("[PeerManager] Created peer %s (type: %s, room: %s)", peerID, peerType, room)
 	return peer, nil
 }
 
+// Suspend starts a peer's grace period: the peer is removed when it expires
+// unless it reconnects first. Without a grace period the peer is removed now.
+func (pm *PeerManager) Suspend(peerID string) {
+	peer := pm.GetPeer(peerID)
+	if peer == nil {
+		return
+	}
+
+	pm.mu.RLock()
+	grace := pm.gracePeriod
+	handler := pm.onSuspend
+	pm.mu.RUnlock()
+
+	if grace <= 0 {
+		pm.RemovePeer(peerID)
+		return
+	}
+
+	peer.mu.Lock()
+	if peer.grace != nil {
+		peer.mu.Unlock()
+		return // Already suspended
+	}
+	peer.grace = time.AfterFunc(grace, func() {
+		log.Printf("[PeerManager] Peer %s was not resumed within %s", peerID, grace)
+		pm.RemovePeer(peerID)
+	})
+	peer.mu.Unlock()
+
+	log.Printf("[PeerManager] Suspended peer %s for %s", peerID, grace)
+	if handler != nil {
+		handler(peer)
+	}
+}
+
+// ResumePeer returns the peer for a resumption offer if the session token
+// matches. A peer that is still connected (only its signaling socket was
+// lost) leaves its grace period at once; otherwise the grace period restarts
+// so the ICE restart has time to complete. Returns nil if the peer doesn't
+// exist or the token is wrong.
+func (pm *PeerManager) ResumePeer(peerID, token string) *Peer {
+	peer := pm.GetPeer(peerID)
+	if peer == nil || subtle.ConstantTimeCompare([]byte(peer.Token), []byte(token)) != 1 {
+		return nil
+	}
+
+	if peer.Connection.ConnectionState() == webrtc.PeerConnectionStateConnected {
+		pm.stopGrace(peer)
+	}
+
+	pm.mu.RLock()
+	grace := pm.gracePeriod
+	pm.mu.RUnlock()
+
+	peer.mu.Lock()
+	if peer.grace != nil {
+		peer.grace.Reset(grace)
+	}
+
+	// The ICE restart gathers a fresh set of candidates
+	peer.localCandidates = nil
+	peer.gatheringDone = false
+	peer.mu.Unlock()
+
+	log.Printf("[PeerManager] Resuming peer %s", peerID)
+	return peer
+}
+
+// stopGrace ends a peer's grace period once it has reconnected.
+func (pm *PeerManager) stopGrace(peer *Peer) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if peer.grace != nil {
+		peer.grace.Stop()
+		peer.grace = nil
+		log.Printf("[PeerManager] Peer %s resumed", peer.ID)
+	}
+}
+
+// AcceptOffer applies a remote SDP offer to the peer and sets the local answer.
+// Returns the answer without waiting for ICE gathering.
+func (pm *PeerManager) AcceptOffer(peer *Peer, sdp string) (webrtc.SessionDescription, error) {
+	offer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  sdp,
+	}
+	if err := peer.Connection.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("invalid SDP offer: %w", err)
+	}
+
+	answer, err := peer.Connection.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	if err := peer.Connection.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	return answer, nil
+}
+
+// CreateOffer creates an SDP offer for a peer the relay initiates and sets it
+// as the local description. Returns the offer without waiting for ICE gathering.
+func (pm *PeerManager) CreateOffer(peer *Peer) (webrtc.SessionDescription, error) {
+	offer, err := peer.Connection.CreateOffer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	if err := peer.Connection.SetLocalDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	return offer, nil
+}
+
+// AcceptAnswer applies a remote SDP answer to an offer created by CreateOffer.
+func (pm *PeerManager) AcceptAnswer(peer *Peer, sdp string) error {
+	answer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  sdp,
+	}
+	if err := peer.Connection.SetRemoteDescription(answer); err != nil {
+		return fmt.Errorf("invalid SDP answer: %w", err)
+	}
+	return nil
+}
+
+// AddReceiver adds a receive-only transceiver of the given kind, so that an
+// offer created by CreateOffer lets the peer send a track of that kind.
+func (pm *PeerManager) AddReceiver(peer *Peer, kind webrtc.RTPCodecType) error {
+	_, err := peer.Connection.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s receiver: %w", kind, err)
+	}
+	return nil
+}
+
+// Renegotiate sends the peer a new SDP offer over its primary data channel,
+// e.g. after tracks were added or removed. If an offer is still awaiting its
+// answer, another one is sent once the answer arrives.
+func (pm *PeerManager) Renegotiate(peer *Peer) error {
+	peer.mu.Lock()
+	if peer.negotiating {
+		peer.renegotiate = true
+		peer.mu.Unlock()
+		return nil
+	}
+	peer.negotiating = true
+	peer.mu.Unlock()
+
+	if err := pm.sendOffer(peer); err != nil {
+		peer.mu.Lock()
+		peer.negotiating = false
+		peer.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// sendOffer creates an offer and sends it over the peer's primary channel.
+// ICE candidates from the initial connection are included in the SDP.
+func (pm *PeerManager) sendOffer(peer *Peer) error {
+	gathered := webrtc.GatheringCompletePromise(peer.Connection)
+	if _, err := pm.CreateOffer(peer); err != nil {
+		return err
+	}
+	<-gathered
+
+	msg, err := json.Marshal(DataMessage{Type: "offer", SDP: peer.Connection.LocalDescription().SDP})
+	if err != nil {
+		return err
+	}
+	log.Printf("[Peer %s] Sending renegotiation offer", peer.ID)
+	return pm.SendTextToPeer(peer.ID, msg)
+}
+
+// AcceptRenegotiation applies the peer's answer to an offer sent by
+// Renegotiate, then sends any offer that was queued meanwhile.
+func (pm *PeerManager) AcceptRenegotiation(peer *Peer, sdp string) error {
+	peer.mu.RLock()
+	negotiating := peer.negotiating
+	peer.mu.RUnlock()
+	if !negotiating {
+		return fmt.Errorf("peer %s has no renegotiation in progress", peer.ID)
+	}
+
+	err := pm.AcceptAnswer(peer, sdp)
+
+	peer.mu.Lock()
+	again := peer.renegotiate
+	peer.negotiating = false
+	peer.renegotiate = false
+	peer.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if again {
+		return pm.Renegotiate(peer)
+	}
+	return nil
+}
+
+// handleLocalCandidate queues or delivers a gathered local ICE candidate.
+// A nil candidate marks the end of gathering.
+func (pm *PeerManager) handleLocalCandidate(peer *Peer, candidate *webrtc.ICECandidate) {
+	peer.mu.Lock()
+	if candidate == nil {
+		peer.gatheringDone = true
+		peer.mu.Unlock()
+		log.Printf("[Peer %s] ICE gathering complete", peer.ID)
+		return
+	}
+
+	init := candidate.ToJSON()
+	handler := peer.onCandidate
+	if handler == nil {
+		peer.localCandidates = append(peer.localCandidates, init)
+	}
+	peer.mu.Unlock()
+
+	if handler != nil {
+		handler(init)
+	}
+}
+
+// PollCandidates returns the local ICE candidates gathered since the last
+// poll and whether gathering has completed.
+func (pm *PeerManager) PollCandidates(peerID string) ([]webrtc.ICECandidateInit, bool, error) {
+	peer := pm.GetPeer(peerID)
+	if peer == nil {
+		return nil, false, fmt.Errorf("peer %s not found", peerID)
+	}
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	candidates := peer.localCandidates
+	peer.localCandidates = nil
+	if candidates == nil {
+		candidates = []webrtc.ICECandidateInit{}
+	}
+	return candidates, peer.gatheringDone, nil
+}
+
 // CreateDataChannel creates a new DataChannel on the peer connection.
-// Used when this peer is the offerer.
+// Used when this peer is the offerer. The command channel is unordered and
+// unreliable; every other label is reliable and ordered.
 func (pm *PeerManager) CreateDataChannel(peer *Peer, label string) error {
-	// Data channel configuration for reliable, ordered delivery
-	ordered := true
-	dc, err := peer.Connection.CreateDataChannel(label, &webrtc.DataChannelInit{
-		Ordered: &ordered,
-	})
+	dc, err := peer.Connection.CreateDataChannel(label, pm.channelInit(label))
 	if err != nil {
 		return fmt.Errorf("failed to create data channel: %w", err)
 	}
@@ -138,18 +585,82 @@ func (pm *PeerManager) CreateDataChannel(peer *Peer, label string) error {
 	return nil
 }
 
+// channelInit returns the delivery options for a data channel label.
+func (pm *PeerManager) channelInit(label string) *webrtc.DataChannelInit {
+	ordered := label != ChannelCommand
+	init := &webrtc.DataChannelInit{Ordered: &ordered}
+	if label != ChannelCommand {
+		return init
+	}
+
+	pm.mu.RLock()
+	lifetime := pm.commandLifetime
+	pm.mu.RUnlock()
+
+	if lifetime > 0 {
+		// The SCTP field is 16 bits wide; clamp instead of wrapping around
+		ms := uint16(math.MaxUint16)
+		if lifetime.Milliseconds() < math.MaxUint16 {
+			ms = uint16(lifetime.Milliseconds())
+		}
+		init.MaxPacketLifeTime = &ms
+	} else {
+		var retransmits uint16
+		init.MaxRetransmits = &retransmits
+	}
+	return init
+}
+
 // setupDataChannel configures event handlers for a DataChannel.
+// The first channel other than the command channel becomes the primary channel.
 func (pm *PeerManager) setupDataChannel(peer *Peer, dc *webrtc.DataChannel) {
+	label := dc.Label()
+	isPrimary := false
+
 	peer.mu.Lock()
-	peer.DataChannel = dc
+	if peer.channels == nil {
+		peer.channels = make(map[string]*webrtc.DataChannel)
+	}
+	peer.channels[label] = dc
+	if peer.primary == nil && label != ChannelCommand {
+		peer.primary = dc
+		isPrimary = true
+	}
 	peer.mu.Unlock()
 
+	if label == ChannelCommand && (dc.Ordered() || (dc.MaxRetransmits() == nil && dc.MaxPacketLifeTime() == nil)) {
+		log.Printf("[Peer %s] Warning: command channel is reliable or ordered", peer.ID)
+	}
+
 	dc.OnOpen(func() {
-		log.Printf("[Peer %s] DataChannel '%s' opened", peer.ID, dc.Label())
+		log.Printf("[Peer %s] DataChannel '%s' opened", peer.ID, label)
+
+		// Join once the primary channel can carry the initial state
+		peer.mu.Lock()
+		join := isPrimary && !peer.joined
+		peer.joined = true
+		peer.mu.Unlock()
+		if !join {
+			return
+		}
+
+		pm.mu.RLock()
+		handler := pm.onOpen
+		pm.mu.RUnlock()
+
+		if handler != nil {
+			handler(peer)
+		}
 	})
 
 	dc.OnClose(func() {
-		log.Printf("[Peer %s] DataChannel '%s' closed", peer.ID, dc.Label())
+		log.Printf("[Peer %s] DataChannel '%s' closed", peer.ID, label)
+
+		peer.mu.Lock()
+		if peer.channels[label] == dc {
+			delete(peer.channels, label)
+		}
+		peer.mu.Unlock()
 	})
 
 	dc.OnError(func(err error) {
@@ -160,8 +671,20 @@ func (pm *PeerManager) setupDataChannel(peer *Peer, dc *webrtc.DataChannel) {
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		pm.mu.RLock()
 		handler := pm.onMessage
+		limiter := pm.limiter
 		pm.mu.RUnlock()
 
+		// Never block here: pion delivers every channel of the association
+		// from one read loop, so a delay would hold back e-stops too
+		switch limiter.AdmitNoWait(peer.ID, peer.Addr, len(msg.Data)) {
+		case RateDrop:
+			return
+		case RateDisconnect:
+			// Not from within the connection's own callback
+			go pm.RemovePeer(peer.ID)
+			return
+		}
+
 		if handler != nil {
 			handler(peer, msg.Data)
 		}
@@ -190,25 +713,77 @@ func (pm *PeerManager) GetPeersByType(peerType PeerType) []*Peer {
 	return result
 }
 
+// GetRoomPeers returns all peers of the specified type in a room.
+func (pm *PeerManager) GetRoomPeers(room string, peerType PeerType) []*Peer {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var result []*Peer
+	for _, peer := range pm.rooms[room] {
+		if peer.Type == peerType {
+			result = append(result, peer)
+		}
+	}
+	return result
+}
+
+// RoomCounts returns the number of peers of each type per room.
+func (pm *PeerManager) RoomCounts() map[string]RoomCount {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	counts := make(map[string]RoomCount, len(pm.rooms))
+	for room, peers := range pm.rooms {
+		var count RoomCount
+		for _, peer := range peers {
+			if peer.Type == PeerTypePython {
+				count.Python++
+			} else {
+				count.Web++
+			}
+		}
+		counts[room] = count
+	}
+	return counts
+}
+
 // RemovePeer removes and closes a peer connection.
 func (pm *PeerManager) RemovePeer(peerID string) {
 	pm.mu.Lock()
 	peer, exists := pm.peers[peerID]
 	if exists {
 		delete(pm.peers, peerID)
+		delete(pm.rooms[peer.Room], peerID)
+		if len(pm.rooms[peer.Room]) == 0 {
+			delete(pm.rooms, peer.Room)
+		}
 	}
+	handler := pm.onRemove
 	pm.mu.Unlock()
 
+	if exists {
+		peer.mu.Lock()
+		if peer.grace != nil {
+			peer.grace.Stop()
+			peer.grace = nil
+		}
+		peer.mu.Unlock()
+	}
+
 	if exists && peer.Connection != nil {
 		peer.Connection.Close()
 		log.Printf("[PeerManager] Removed peer %s", peerID)
 	}
+
+	if exists && handler != nil {
+		handler(peer)
+	}
 }
 
-// BroadcastToType sends data to all peers of the specified type.
+// BroadcastToType sends data to all peers of the specified type in a room.
 // Returns the number of peers that received the message.
-func (pm *PeerManager) BroadcastToType(peerType PeerType, data []byte) int {
-	peers := pm.GetPeersByType(peerType)
+func (pm *PeerManager) BroadcastToType(room string, peerType PeerType, data []byte) int {
+	peers := pm.GetRoomPeers(room, peerType)
 	sent := 0
 
 	for _, peer := range peers {
@@ -220,26 +795,90 @@ func (pm *PeerManager) BroadcastToType(peerType PeerType, data []byte) int {
 	return sent
 }
 
-// SendToPeer sends binary data to a specific peer via its DataChannel.
+// BroadcastCommandToType sends a motion command to all peers of the specified
+// type in a room, preferring their command channel.
+// Returns the number of peers that received the message.
+func (pm *PeerManager) BroadcastCommandToType(room string, peerType PeerType, data []byte) int {
+	peers := pm.GetRoomPeers(room, peerType)
+	sent := 0
+
+	for _, peer := range peers {
+		if err := pm.SendCommandToPeer(peer.ID, data); err == nil {
+			sent++
+		}
+	}
+
+	return sent
+}
+
+// BroadcastTextToType sends a text message to all peers of the specified type in a room.
+// Returns the number of peers that received the message.
+func (pm *PeerManager) BroadcastTextToType(room string, peerType PeerType, data []byte) int {
+	peers := pm.GetRoomPeers(room, peerType)
+	sent := 0
+
+	for _, peer := range peers {
+		if err := pm.SendTextToPeer(peer.ID, data); err == nil {
+			sent++
+		}
+	}
+
+	return sent
+}
+
+// SendToPeer sends binary data to a specific peer via its primary DataChannel.
 func (pm *PeerManager) SendToPeer(peerID string, data []byte) error {
+	dc, err := pm.openDataChannel(peerID, "")
+	if err != nil {
+		return err
+	}
+	return dc.Send(data)
+}
+
+// SendTextToPeer sends a text message (e.g. JSON) to a specific peer via its primary DataChannel.
+func (pm *PeerManager) SendTextToPeer(peerID string, data []byte) error {
+	dc, err := pm.openDataChannel(peerID, "")
+	if err != nil {
+		return err
+	}
+	return dc.SendText(string(data))
+}
+
+// SendCommandToPeer sends a binary motion command to a specific peer via its
+// command channel, falling back to the primary channel if it has none open.
+func (pm *PeerManager) SendCommandToPeer(peerID string, data []byte) error {
+	dc, err := pm.openDataChannel(peerID, ChannelCommand)
+	if err != nil {
+		return err
+	}
+	return dc.Send(data)
+}
+
+// openDataChannel returns the peer's channel with the given label if it is
+// open for sending, else its primary channel. An empty label selects the
+// primary channel.
+func (pm *PeerManager) openDataChannel(peerID, label string) (*webrtc.DataChannel, error) {
 	peer := pm.GetPeer(peerID)
 	if peer == nil {
-		return fmt.Errorf("peer %s not found", peerID)
+		return nil, fmt.Errorf("peer %s not found", peerID)
 	}
 
 	peer.mu.RLock()
-	dc := peer.DataChannel
+	dc := peer.primary
+	if ch := peer.channels[label]; ch != nil && ch.ReadyState() == webrtc.DataChannelStateOpen {
+		dc = ch
+	}
 	peer.mu.RUnlock()
 
 	if dc == nil {
-		return fmt.Errorf("peer %s has no data channel", peerID)
+		return nil, fmt.Errorf("peer %s has no data channel", peerID)
 	}
 
 	if dc.ReadyState() != webrtc.DataChannelStateOpen {
-		return fmt.Errorf("data channel not open (state: %s)", dc.ReadyState().String())
+		return nil, fmt.Errorf("data channel not open (state: %s)", dc.ReadyState().String())
 	}
 
-	return dc.Send(data)
+	return dc, nil
 }
 
 // PeerCount returns the current number of connected peers.
@@ -260,6 +899,7 @@ func (pm *PeerManager) Close() {
 		}
 		delete(pm.peers, id)
 	}
+	pm.rooms = make(map[string]map[string]*Peer)
 
 	log.Println("[PeerManager] All peers closed")
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestChannelInitCommandLifetime(t *testing.T) {
+	tests := []struct {
+		name        string
+		lifetime    time.Duration
+		lifetimeMs  int // Expected MaxPacketLifeTime (-1 = unset)
+		retransmits int // Expected MaxRetransmits (-1 = unset)
+	}{
+		{"no retransmits", 0, -1, 0},
+		{"lifetime", 150 * time.Millisecond, 150, -1},
+		{"at the limit", 65535 * time.Millisecond, 65535, -1},
+		{"clamped", 65536 * time.Millisecond, 65535, -1},
+		{"far beyond the limit", time.Hour, 65535, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPeerManager(webrtc.Configuration{})
+			pm.SetCommandLifetime(tt.lifetime)
+
+			init := pm.channelInit(ChannelCommand)
+			if init.Ordered == nil || *init.Ordered {
+				t.Errorf("command channel ordered, want unordered")
+			}
+			if got := optionalUint16(init.MaxPacketLifeTime); got != tt.lifetimeMs {
+				t.Errorf("MaxPacketLifeTime = %d, want %d", got, tt.lifetimeMs)
+			}
+			if got := optionalUint16(init.MaxRetransmits); got != tt.retransmits {
+				t.Errorf("MaxRetransmits = %d, want %d", got, tt.retransmits)
+			}
+		})
+	}
+}
+
+func TestChannelInitReliable(t *testing.T) {
+	pm := NewPeerManager(webrtc.Configuration{})
+	pm.SetCommandLifetime(time.Hour)
+
+	init := pm.channelInit(ChannelReliable)
+	if init.Ordered == nil || !*init.Ordered || init.MaxPacketLifeTime != nil || init.MaxRetransmits != nil {
+		t.Errorf("channelInit(%q) = %+v, want ordered and reliable", ChannelReliable, init)
+	}
+}
+
+// optionalUint16 returns *v, or -1 if v is nil.
+func optionalUint16(v *uint16) int {
+	if v == nil {
+		return -1
+	}
+	return int(*v)
+}
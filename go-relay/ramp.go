@@ -0,0 +1,149 @@
+// Package main provides an acceleration-limiting filter for robot commands.
+//
+// Instead of forwarding operator Twists directly, the router submits them to
+// a RampFilter as the target velocity. The filter runs at a fixed output rate
+// and moves its current command towards the target by at most
+// maxAccel * dt per axis on every tick, so a keyboard jump from 0 to full
+// speed becomes a smooth ramp at the robot. Stop commands bypass the ramp and
+// are emitted immediately.
+//
+// Once the target is reached, the filter only emits when a new command
+// arrives, so a silent operator stops refreshing the robot and the robot's
+// own deadman still applies. Because of this the filter needs the deadman
+// watchdog to stop the robot when the operator stops mid-motion.
+package main
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// RampFilter limits the rate of change of each Twist axis.
+// Thread-safe for concurrent access from multiple goroutines.
+type RampFilter struct {
+	maxLinearAccel  float64       // m/s² per linear axis (0 = unlimited)
+	maxAngularAccel float64       // rad/s² per angular axis (0 = unlimited)
+	period          time.Duration // Output interval
+	emit            func(twist *TwistMessage)
+
+	mu       sync.Mutex
+	target   TwistMessage // Latest accepted command
+	current  TwistMessage // Last command emitted
+	fresh    bool         // A command arrived since the last tick
+	lastTick time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewRampFilter creates a filter that emits ramped commands at rateHz.
+func NewRampFilter(maxLinearAccel, maxAngularAccel, rateHz float64, emit func(twist *TwistMessage)) *RampFilter {
+	return &RampFilter{
+		maxLinearAccel:  maxLinearAccel,
+		maxAngularAccel: maxAngularAccel,
+		period:          time.Duration(float64(time.Second) / rateHz),
+		emit:            emit,
+		stop:            make(chan struct{}),
+	}
+}
+
+// Start launches the fixed-rate output loop.
+func (f *RampFilter) Start() {
+	go func() {
+		ticker := time.NewTicker(f.period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				f.tick(now)
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+
+	log.Printf("[Ramp] Started (linear: %g m/s², angular: %g rad/s², period: %s)",
+		f.maxLinearAccel, f.maxAngularAccel, f.period)
+}
+
+// Stop terminates the output loop.
+func (f *RampFilter) Stop() {
+	f.once.Do(func() {
+		close(f.stop)
+	})
+}
+
+// Submit sets a new target command.
+// Stop commands reset the filter and are emitted immediately.
+func (f *RampFilter) Submit(twist *TwistMessage) {
+	if twist.IsEmergencyStop() {
+		f.Reset()
+		f.emit(twist)
+		return
+	}
+
+	f.mu.Lock()
+	f.target = *twist.Clone()
+	f.fresh = true
+	f.mu.Unlock()
+}
+
+// Reset drops the current target and output without emitting anything.
+// Returns true if the filter was driving the robot, i.e. a stop is needed.
+func (f *RampFilter) Reset() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	moving := !f.target.IsZero() || !f.current.IsZero()
+	f.target = TwistMessage{}
+	f.current = TwistMessage{}
+	f.fresh = false
+	return moving
+}
+
+// tick advances the current command towards the target and emits it.
+// Nothing is emitted once the target is reached until a new command arrives.
+func (f *RampFilter) tick(now time.Time) {
+	f.mu.Lock()
+	dt := now.Sub(f.lastTick).Seconds()
+	if f.lastTick.IsZero() || dt > 2*f.period.Seconds() {
+		dt = f.period.Seconds()
+	}
+	f.lastTick = now
+
+	settled := f.current.Linear == f.target.Linear && f.current.Angular == f.target.Angular
+	if settled && (!f.fresh || f.target.IsZero()) {
+		f.fresh = false
+		f.mu.Unlock()
+		return
+	}
+	f.fresh = false
+
+	rampVector(&f.current.Linear, f.target.Linear, f.maxLinearAccel*dt)
+	rampVector(&f.current.Angular, f.target.Angular, f.maxAngularAccel*dt)
+	f.current.Timestamp = f.target.Timestamp
+	out := f.current.Clone()
+	f.mu.Unlock()
+
+	f.emit(out)
+}
+
+// rampVector moves each axis of current towards target by at most step.
+// A non-positive step means the axis is unlimited.
+func rampVector(current *Vector3, target Vector3, step float64) {
+	current.X = rampAxis(current.X, target.X, step)
+	current.Y = rampAxis(current.Y, target.Y, step)
+	current.Z = rampAxis(current.Z, target.Z, step)
+}
+
+// rampAxis moves value towards target by at most step.
+func rampAxis(value, target, step float64) float64 {
+	delta := target - value
+	if step <= 0 || math.Abs(delta) <= step {
+		return target
+	}
+	return value + math.Copysign(step, delta)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRampFilterTick(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		target TwistMessage
+		ticks  int
+		want   []float64 // linear.x emitted on each tick
+	}{
+		{"ramps up", TwistMessage{Linear: Vector3{X: 1}}, 5, []float64{0.25, 0.5, 0.75, 1}},
+		{"ramps down", TwistMessage{Linear: Vector3{X: -0.5}}, 3, []float64{-0.25, -0.5}},
+		{"within one step", TwistMessage{Linear: Vector3{X: 0.1}}, 3, []float64{0.1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []float64
+			f := NewRampFilter(2.5, 0, 10, func(twist *TwistMessage) {
+				got = append(got, twist.Linear.X)
+			})
+
+			target := tt.target
+			f.Submit(&target)
+			for i := 0; i < tt.ticks; i++ {
+				f.tick(start.Add(time.Duration(i) * 100 * time.Millisecond))
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("emitted %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if diff := got[i] - tt.want[i]; diff > 1e-9 || diff < -1e-9 {
+					t.Errorf("emitted %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRampFilterSettledRefresh(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	emitted := 0
+	f := NewRampFilter(0, 0, 10, func(*TwistMessage) { emitted++ })
+
+	f.Submit(&TwistMessage{Angular: Vector3{Z: 1}})
+	f.tick(start)
+	f.tick(start.Add(100 * time.Millisecond))
+	if emitted != 1 {
+		t.Fatalf("emitted %d commands for one target, want 1", emitted)
+	}
+
+	// A repeated command refreshes the robot even though nothing changes
+	f.Submit(&TwistMessage{Angular: Vector3{Z: 1}})
+	f.tick(start.Add(200 * time.Millisecond))
+	if emitted != 2 {
+		t.Errorf("emitted %d commands after a refresh, want 2", emitted)
+	}
+}
+
+func TestRampFilterStop(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var got []*TwistMessage
+	f := NewRampFilter(1, 1, 10, func(twist *TwistMessage) { got = append(got, twist) })
+
+	f.Submit(&TwistMessage{Linear: Vector3{X: 1}})
+	f.tick(start)
+
+	// Stops bypass the ramp and clear the target
+	f.Submit(EmergencyStop())
+	if len(got) != 2 || !got[1].IsZero() {
+		t.Fatalf("emitted %+v, want the ramped command and an immediate stop", got)
+	}
+	f.tick(start.Add(100 * time.Millisecond))
+	if len(got) != 2 {
+		t.Errorf("emitted %+v after the stop, want nothing", got[2:])
+	}
+}
+
+func TestRampFilterReset(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	emitted := 0
+	f := NewRampFilter(1, 1, 10, func(*TwistMessage) { emitted++ })
+
+	if f.Reset() {
+		t.Errorf("Reset() of an idle filter = true, want false")
+	}
+
+	f.Submit(&TwistMessage{Linear: Vector3{X: 1}})
+	f.tick(start)
+	if !f.Reset() {
+		t.Errorf("Reset() while driving = false, want true")
+	}
+
+	f.tick(start.Add(100 * time.Millisecond))
+	if emitted != 1 {
+		t.Errorf("emitted %d commands, want nothing after Reset()", emitted-1)
+	}
+}
+
+func TestRampResetOnLeaseLoss(t *testing.T) {
+	tests := []struct {
+		name string
+		lose func(mr *MessageRouter, room *Room)
+	}{
+		{"released", func(mr *MessageRouter, room *Room) {
+			mr.HandleDisconnect("operator", room.ID)
+		}},
+		{"expired", func(mr *MessageRouter, room *Room) {
+			room.lease.expire(time.Now().Add(time.Minute))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := NewMessageRouter(NewPeerManager(webrtc.Configuration{}))
+			mr.EnableRamp(1, 1, 1)
+			mr.EnableLease(time.Second, false)
+
+			room := mr.room("robot1")
+			defer room.Stop()
+			if _, ok := room.lease.Acquire("operator"); !ok {
+				t.Fatal("Acquire() failed on a free lease")
+			}
+			room.ramp.Submit(&TwistMessage{Linear: Vector3{X: 1}})
+
+			tt.lose(mr, room)
+			if room.ramp.Reset() {
+				t.Errorf("ramp still driving after the lease was lost")
+			}
+		})
+	}
+}
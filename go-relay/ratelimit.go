@@ -0,0 +1,358 @@
+// Package main provides per-client and per-IP rate limiting.
+//
+// Every message a client sends over a WebSocket or DataChannel is charged
+// against token buckets for its message rate and byte rate, both for the
+// client and for the IP address it connected from, so one host can't get
+// around the limits by opening more connections. Each bucket holds one
+// second's worth of traffic. What happens to a message that doesn't fit is
+// configurable:
+//   - drop       - discard it
+//   - throttle   - delay it until it fits, pushing back on the sender;
+//     messages that would wait longer than maxThrottleWait are dropped.
+//     DataChannel messages are dropped instead, since delaying one would
+//     stall every channel of the peer's association, e-stops included
+//   - disconnect - close the client's connection
+//
+// Connection attempts (/offer, /connect and the /ws/* upgrades) are limited
+// per IP separately; rejected attempts get 429.
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxThrottleWait bounds how long a throttled message may be delayed.
+	maxThrottleWait = time.Second
+
+	// rateIdleTimeout is how long an unused bucket is kept before it is pruned.
+	rateIdleTimeout = 2 * time.Minute
+
+	// rateLogInterval limits violation logs to one per client per interval.
+	rateLogInterval = 5 * time.Second
+)
+
+// RateLimitAction selects what happens to messages over the limit.
+type RateLimitAction string
+
+const (
+	RateLimitDrop       RateLimitAction = "drop"
+	RateLimitThrottle   RateLimitAction = "throttle"
+	RateLimitDisconnect RateLimitAction = "disconnect"
+)
+
+// RateVerdict is the outcome of charging a message to its sender.
+type RateVerdict int
+
+const (
+	RateAllow      RateVerdict = iota // Deliver the message
+	RateDrop                          // Discard the message
+	RateDisconnect                    // Close the sender's connection
+)
+
+// RateLimitConfig holds the limits. A zero rate disables that limit.
+type RateLimitConfig struct {
+	Messages     float64         // Messages per second per client
+	Bytes        float64         // Bytes per second per client
+	IPMessages   float64         // Messages per second per IP
+	IPBytes      float64         // Bytes per second per IP
+	Connects     float64         // Connection attempts per minute per IP
+	ConnectBurst int             // Connection attempts allowed back to back
+	Action       RateLimitAction // What to do with messages over the limit
+}
+
+// RateLimitStats counts rate limit violations.
+type RateLimitStats struct {
+	Dropped          uint64 `json:"dropped"`           // Messages discarded for exceeding a limit
+	Throttled        uint64 `json:"throttled"`         // Messages delayed to fit a limit
+	Disconnected     uint64 `json:"disconnected"`      // Connections closed for exceeding a limit
+	ConnectsRejected uint64 `json:"connects_rejected"` // Connection attempts refused with 429
+}
+
+// tokenBucket refills at rate tokens per second up to burst.
+// Tokens go negative when a throttled message is charged in advance.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket, or nil if rate is zero (unlimited).
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// wait returns how long until n tokens are available (0 if they are now).
+// Returns a negative duration if n exceeds the burst and never fits.
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if n > b.burst {
+		return -1
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take removes n tokens.
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+// rateEntry holds the buckets of one client or IP.
+type rateEntry struct {
+	messages *tokenBucket
+	bytes    *tokenBucket
+	seen     time.Time // Last use, for pruning
+	logged   time.Time // Last violation log
+}
+
+// RateLimiter enforces message and connection limits.
+// Thread-safe for concurrent access from multiple goroutines.
+type RateLimiter struct {
+	config RateLimitConfig
+
+	clients  map[string]*rateEntry // Indexed by client ID
+	ips      map[string]*rateEntry // Indexed by IP
+	connects map[string]*rateEntry // Connection attempts, indexed by IP
+	pruned   time.Time
+	mu       sync.Mutex // Protects the maps and buckets
+
+	dropped          atomic.Uint64
+	throttled        atomic.Uint64
+	disconnected     atomic.Uint64
+	connectsRejected atomic.Uint64
+}
+
+// NewRateLimiter creates a rate limiter with the given limits.
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:   config,
+		clients:  make(map[string]*rateEntry),
+		ips:      make(map[string]*rateEntry),
+		connects: make(map[string]*rateEntry),
+		pruned:   time.Now(),
+	}
+}
+
+// Admit charges a message of size bytes to a client and its IP and decides
+// its fate. With the throttle action, Admit sleeps until the message fits,
+// so callers must call it from a read loop that serves only that sender.
+func (l *RateLimiter) Admit(clientID, ip string, size int) RateVerdict {
+	if l == nil {
+		return RateAllow
+	}
+
+	verdict, wait := l.charge(clientID, ip, size, l.config.Action == RateLimitThrottle, time.Now())
+	if verdict == RateAllow && wait > 0 {
+		time.Sleep(wait)
+	}
+	return verdict
+}
+
+// AdmitNoWait is like Admit but never blocks: with the throttle action,
+// messages over the limit are dropped. Used where a delay would stall other
+// traffic, e.g. in a DataChannel message callback.
+func (l *RateLimiter) AdmitNoWait(clientID, ip string, size int) RateVerdict {
+	verdict, _ := l.charge(clientID, ip, size, false, time.Now())
+	return verdict
+}
+
+// charge implements Admit at time now. If throttle is set, a message that
+// fits within maxThrottleWait is allowed and the delay is returned.
+func (l *RateLimiter) charge(clientID, ip string, size int, throttle bool, now time.Time) (RateVerdict, time.Duration) {
+	if l == nil {
+		return RateAllow, 0
+	}
+
+	l.mu.Lock()
+	l.prune(now)
+	client := l.entry(l.clients, clientID, l.config.Messages, l.config.Bytes, now)
+	host := l.entry(l.ips, ip, l.config.IPMessages, l.config.IPBytes, now)
+
+	// The message waits for the slowest of the four buckets
+	var wait time.Duration
+	for _, w := range []time.Duration{
+		client.messages.wait(1, now), client.bytes.wait(float64(size), now),
+		host.messages.wait(1, now), host.bytes.wait(float64(size), now),
+	} {
+		if w < 0 || wait < 0 {
+			wait = -1
+		} else if w > wait {
+			wait = w
+		}
+	}
+
+	throttled := throttle && wait > 0 && wait <= maxThrottleWait
+	if wait == 0 || throttled {
+		client.messages.take(1)
+		client.bytes.take(float64(size))
+		host.messages.take(1)
+		host.bytes.take(float64(size))
+	}
+	shouldLog := wait != 0 && now.Sub(client.logged) >= rateLogInterval
+	if shouldLog {
+		client.logged = now
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return RateAllow, 0
+	}
+
+	verdict := RateDrop
+	switch {
+	case l.config.Action == RateLimitDisconnect:
+		verdict = RateDisconnect
+		l.disconnected.Add(1)
+	case throttled:
+		verdict = RateAllow
+		l.throttled.Add(1)
+	default:
+		l.dropped.Add(1)
+	}
+
+	if shouldLog {
+		log.Printf("[RateLimit] %s (%s) over the limit (%d bytes): %s", clientID, ip, size, l.verdictName(verdict))
+	}
+	if verdict == RateAllow {
+		return verdict, wait
+	}
+	return verdict, 0
+}
+
+// verdictName describes a verdict for logs.
+func (l *RateLimiter) verdictName(verdict RateVerdict) string {
+	switch verdict {
+	case RateAllow:
+		return "throttled"
+	case RateDisconnect:
+		return "disconnecting"
+	}
+	return "dropped"
+}
+
+// AllowConnect charges a connection attempt to the request's IP. Attempts
+// over the limit return false and how long until the next one would fit;
+// callers answer them with 429 (see setRetryAfter).
+func (l *RateLimiter) AllowConnect(r *http.Request, tag string) (ok bool, retry time.Duration) {
+	if l == nil || l.config.Connects <= 0 {
+		return true, 0
+	}
+
+	ip := clientIP(r)
+	now := time.Now()
+	l.mu.Lock()
+	l.prune(now)
+	entry, ok := l.connects[ip]
+	if !ok {
+		burst := math.Max(1, float64(l.config.ConnectBurst))
+		entry = &rateEntry{messages: newTokenBucket(l.config.Connects/60, burst, now)}
+		l.connects[ip] = entry
+	}
+	entry.seen = now
+	wait := entry.messages.wait(1, now)
+	if wait == 0 {
+		entry.messages.take(1)
+	}
+	shouldLog := wait != 0 && now.Sub(entry.logged) >= rateLogInterval
+	if shouldLog {
+		entry.logged = now
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return true, 0
+	}
+
+	l.connectsRejected.Add(1)
+	if shouldLog {
+		log.Printf("[%s] Too many connection attempts from %s", tag, ip)
+	}
+	return false, wait
+}
+
+// setRetryAfter tells a client over the connection limit when to retry.
+func setRetryAfter(w http.ResponseWriter, retry time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+}
+
+// Stats returns the violation counters.
+func (l *RateLimiter) Stats() RateLimitStats {
+	if l == nil {
+		return RateLimitStats{}
+	}
+	return RateLimitStats{
+		Dropped:          l.dropped.Load(),
+		Throttled:        l.throttled.Load(),
+		Disconnected:     l.disconnected.Load(),
+		ConnectsRejected: l.connectsRejected.Load(),
+	}
+}
+
+// String describes the limits for the startup log.
+func (l *RateLimiter) String() string {
+	c := l.config
+	return fmt.Sprintf("client %g msg/s %.0f B/s, IP %g msg/s %.0f B/s, %g connects/min (burst %d), action=%s",
+		c.Messages, c.Bytes, c.IPMessages, c.IPBytes, c.Connects, c.ConnectBurst, c.Action)
+}
+
+// entry returns the buckets for key, creating them on first use.
+// Buckets hold one second's worth of traffic.
+func (l *RateLimiter) entry(entries map[string]*rateEntry, key string, messages, bytes float64, now time.Time) *rateEntry {
+	entry, ok := entries[key]
+	if !ok {
+		entry = &rateEntry{
+			messages: newTokenBucket(messages, messages, now),
+			bytes:    newTokenBucket(bytes, bytes, now),
+		}
+		entries[key] = entry
+	}
+	entry.seen = now
+	return entry
+}
+
+// prune drops buckets that have been idle for rateIdleTimeout.
+// Called with l.mu held; does the scan at most once per timeout.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < rateIdleTimeout {
+		return
+	}
+	l.pruned = now
+
+	for _, entries := range []map[string]*rateEntry{l.clients, l.ips, l.connects} {
+		for key, entry := range entries {
+			if now.Sub(entry.seen) >= rateIdleTimeout {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+// clientIP returns the IP address a request came from.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
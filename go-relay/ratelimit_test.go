@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name    string
+		spent   float64       // Tokens taken at start
+		elapsed time.Duration // Time until the request
+		n       float64
+		want    time.Duration
+	}{
+		{"full", 0, 0, 1, 0},
+		{"exactly enough", 9, 0, 1, 0},
+		{"empty", 10, 0, 1, 100 * time.Millisecond},
+		{"in debt", 15, 0, 1, 600 * time.Millisecond},
+		{"refilled", 10, 100 * time.Millisecond, 1, 0},
+		{"partly refilled", 10, 50 * time.Millisecond, 1, 50 * time.Millisecond},
+		{"refill capped at burst", 0, time.Hour, 10, 0},
+		{"larger than burst", 0, 0, 11, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(10, 10, start)
+			b.take(tt.spent)
+			if got := b.wait(tt.n, start.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("wait(%g) = %s, want %s", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	b := newTokenBucket(0, 0, time.Now())
+	if b != nil {
+		t.Fatalf("newTokenBucket(0) = %+v, want nil", b)
+	}
+	if got := b.wait(1e9, time.Now()); got != 0 {
+		t.Errorf("nil bucket wait() = %s, want 0", got)
+	}
+	b.take(1) // Must not panic
+}
+
+func TestRateLimiterCharge(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		config   RateLimitConfig
+		throttle bool
+		sent     int // Messages of size bytes charged before the checked one
+		size     int
+		verdict  RateVerdict
+		wait     time.Duration
+	}{
+		{"under limit", RateLimitConfig{Messages: 10, Action: RateLimitDrop}, false, 5, 10, RateAllow, 0},
+		{"at limit", RateLimitConfig{Messages: 10, Action: RateLimitDrop}, false, 9, 10, RateAllow, 0},
+		{"drop", RateLimitConfig{Messages: 10, Action: RateLimitDrop}, false, 10, 10, RateDrop, 0},
+		{"disconnect", RateLimitConfig{Messages: 10, Action: RateLimitDisconnect}, false, 10, 10, RateDisconnect, 0},
+		{"throttle", RateLimitConfig{Messages: 10, Action: RateLimitThrottle}, true, 10, 10, RateAllow, 100 * time.Millisecond},
+		{"throttle without waiting", RateLimitConfig{Messages: 10, Action: RateLimitThrottle}, false, 10, 10, RateDrop, 0},
+		{"throttle too long", RateLimitConfig{Messages: 1, Action: RateLimitThrottle}, true, 3, 10, RateDrop, 0},
+		{"byte limit", RateLimitConfig{Bytes: 100, Action: RateLimitDrop}, false, 9, 20, RateDrop, 0},
+		{"larger than byte burst", RateLimitConfig{Bytes: 100, Action: RateLimitThrottle}, true, 0, 101, RateDrop, 0},
+		{"unlimited", RateLimitConfig{Action: RateLimitDrop}, false, 1000, 1000, RateAllow, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.config)
+			for i := 0; i < tt.sent; i++ {
+				l.charge("client", "10.0.0.1", tt.size, tt.throttle, start)
+			}
+
+			verdict, wait := l.charge("client", "10.0.0.1", tt.size, tt.throttle, start)
+			if verdict != tt.verdict || wait != tt.wait {
+				t.Errorf("charge() = %d, %s; want %d, %s", verdict, wait, tt.verdict, tt.wait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSharedIP(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	l := NewRateLimiter(RateLimitConfig{Messages: 10, IPMessages: 15, Action: RateLimitDrop})
+
+	for i := 0; i < 10; i++ {
+		if verdict, _ := l.charge("a", "10.0.0.1", 1, false, start); verdict != RateAllow {
+			t.Fatalf("message %d from a: verdict %d, want allow", i, verdict)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if verdict, _ := l.charge("b", "10.0.0.1", 1, false, start); verdict != RateAllow {
+			t.Fatalf("message %d from b: verdict %d, want allow", i, verdict)
+		}
+	}
+
+	// b is under its own limit, but the IP is not
+	if verdict, _ := l.charge("b", "10.0.0.1", 1, false, start); verdict != RateDrop {
+		t.Errorf("message over the IP limit: verdict %d, want drop", verdict)
+	}
+	// Another IP is unaffected
+	if verdict, _ := l.charge("c", "10.0.0.2", 1, false, start); verdict != RateAllow {
+		t.Errorf("message from another IP: verdict %d, want allow", verdict)
+	}
+
+	if stats := l.Stats(); stats.Dropped != 1 {
+		t.Errorf("Stats().Dropped = %d, want 1", stats.Dropped)
+	}
+}
+
+func TestRateLimiterAdmitNoWait(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{Messages: 1, Action: RateLimitThrottle})
+
+	if verdict := l.AdmitNoWait("client", "10.0.0.1", 1); verdict != RateAllow {
+		t.Fatalf("first message: verdict %d, want allow", verdict)
+	}
+
+	done := make(chan RateVerdict, 1)
+	go func() { done <- l.AdmitNoWait("client", "10.0.0.1", 1) }()
+	select {
+	case verdict := <-done:
+		if verdict != RateDrop {
+			t.Errorf("message over the limit: verdict %d, want drop", verdict)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("AdmitNoWait blocked on a throttled message")
+	}
+
+	if stats := l.Stats(); stats.Throttled != 0 || stats.Dropped != 1 {
+		t.Errorf("Stats() = %+v, want 1 dropped and none throttled", stats)
+	}
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	var l *RateLimiter
+	if verdict := l.Admit("client", "10.0.0.1", 1); verdict != RateAllow {
+		t.Errorf("nil Admit() = %d, want allow", verdict)
+	}
+	if verdict := l.AdmitNoWait("client", "10.0.0.1", 1); verdict != RateAllow {
+		t.Errorf("nil AdmitNoWait() = %d, want allow", verdict)
+	}
+}
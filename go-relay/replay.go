@@ -0,0 +1,96 @@
+// Package main provides a bounded replay buffer for /ws/data sessions.
+//
+// Every downstream message queued for a data client is numbered and kept in
+// the session's buffer, up to a maximum count and age. When a client resumes
+// its session on a new socket, the messages after the last one it received
+// are replayed before live traffic, so telemetry sent while the client was
+// away is not lost. Messages that must not be replayed, such as motion
+// commands to a robot, are numbered but not buffered. Messages that were
+// skipped or have already been evicted are reported as missed.
+package main
+
+import (
+	"time"
+)
+
+// replayEntry is one buffered message.
+type replayEntry struct {
+	seq  uint64
+	at   time.Time
+	data []byte
+}
+
+// ReplayBuffer keeps the most recent messages sent to a client.
+// Not thread-safe; callers hold the owning session's lock.
+type ReplayBuffer struct {
+	maxCount int
+	maxAge   time.Duration
+	entries  []replayEntry // Oldest first
+	last     uint64        // Sequence number of the last message added
+}
+
+// NewReplayBuffer creates a buffer holding at most maxCount messages no older
+// than maxAge. A zero maxAge keeps messages until they are pushed out by count.
+func NewReplayBuffer(maxCount int, maxAge time.Duration) *ReplayBuffer {
+	return &ReplayBuffer{
+		maxCount: maxCount,
+		maxAge:   maxAge,
+	}
+}
+
+// Add appends a message and evicts what no longer fits.
+// Messages are numbered from 1 in the order they are added.
+func (b *ReplayBuffer) Add(data []byte, now time.Time) {
+	b.last++
+	if b.maxCount <= 0 {
+		return
+	}
+
+	b.entries = append(b.entries, replayEntry{seq: b.last, at: now, data: data})
+	if over := len(b.entries) - b.maxCount; over > 0 {
+		b.entries = b.entries[over:]
+	}
+	b.prune(now)
+}
+
+// Skip numbers a message without buffering it, so it is never replayed.
+func (b *ReplayBuffer) Skip() {
+	b.last++
+}
+
+// Last returns the sequence number of the last message added (0 if none).
+func (b *ReplayBuffer) Last() uint64 {
+	return b.last
+}
+
+// Since returns the buffered messages after sequence number seq, oldest
+// first, and how many messages after seq were skipped or evicted before
+// they could be replayed.
+func (b *ReplayBuffer) Since(seq uint64, now time.Time) (messages [][]byte, missed uint64) {
+	b.prune(now)
+	if seq >= b.last {
+		return nil, 0
+	}
+
+	for _, entry := range b.entries {
+		if entry.seq > seq {
+			messages = append(messages, entry.data)
+		}
+	}
+	return messages, b.last - seq - uint64(len(messages))
+}
+
+// prune evicts messages older than maxAge.
+func (b *ReplayBuffer) prune(now time.Time) {
+	if b.maxAge <= 0 {
+		return
+	}
+
+	n := 0
+	for n < len(b.entries) && now.Sub(b.entries[n].at) > b.maxAge {
+		n++
+	}
+	if n > 0 {
+		b.entries = b.entries[n:]
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayBufferSince(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		maxCount int
+		maxAge   time.Duration
+		added    int           // Messages "m1".."mN", one per second from start
+		after    time.Duration // When Since is called, relative to start
+		seq      uint64
+		want     []string
+		missed   uint64
+	}{
+		{"all buffered", 8, 0, 5, 5 * time.Second, 2, []string{"m3", "m4", "m5"}, 0},
+		{"up to date", 8, 0, 5, 5 * time.Second, 5, nil, 0},
+		{"ahead of sender", 8, 0, 5, 5 * time.Second, 9, nil, 0},
+		{"nothing received", 8, 0, 5, 5 * time.Second, 0, []string{"m1", "m2", "m3", "m4", "m5"}, 0},
+		{"wrapped past count", 3, 0, 5, 5 * time.Second, 0, []string{"m3", "m4", "m5"}, 2},
+		{"wrapped, partly missed", 3, 0, 5, 5 * time.Second, 1, []string{"m3", "m4", "m5"}, 1},
+		{"wrapped, nothing missed", 3, 0, 5, 5 * time.Second, 2, []string{"m3", "m4", "m5"}, 0},
+		{"wrapped many times", 3, 0, 100, 100 * time.Second, 50, []string{"m98", "m99", "m100"}, 47},
+		{"aged out", 8, 2500 * time.Millisecond, 5, 6 * time.Second, 0, []string{"m4", "m5"}, 3},
+		{"all aged out", 8, time.Second, 5, time.Minute, 1, nil, 4},
+		{"buffering disabled", 0, 0, 5, 5 * time.Second, 2, nil, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewReplayBuffer(tt.maxCount, tt.maxAge)
+			for i := 1; i <= tt.added; i++ {
+				b.Add([]byte(fmt.Sprintf("m%d", i)), start.Add(time.Duration(i)*time.Second))
+			}
+			if b.Last() != uint64(tt.added) {
+				t.Fatalf("Last() = %d, want %d", b.Last(), tt.added)
+			}
+
+			messages, missed := b.Since(tt.seq, start.Add(tt.after))
+			var got []string
+			for _, m := range messages {
+				got = append(got, string(m))
+			}
+			if !reflect.DeepEqual(got, tt.want) || missed != tt.missed {
+				t.Errorf("Since(%d) = %v, missed %d; want %v, missed %d", tt.seq, got, missed, tt.want, tt.missed)
+			}
+		})
+	}
+}
+
+func TestReplayBufferSkip(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	b := NewReplayBuffer(8, 0)
+
+	b.Add([]byte("m1"), start)
+	b.Skip()
+	b.Skip()
+	b.Add([]byte("m4"), start)
+	b.Skip()
+
+	if b.Last() != 5 {
+		t.Fatalf("Last() = %d, want 5", b.Last())
+	}
+
+	messages, missed := b.Since(0, start)
+	if len(messages) != 2 || string(messages[0]) != "m1" || string(messages[1]) != "m4" || missed != 3 {
+		t.Errorf("Since(0) = %q, missed %d; want [m1 m4], missed 3", messages, missed)
+	}
+
+	messages, missed = b.Since(4, start)
+	if len(messages) != 0 || missed != 1 {
+		t.Errorf("Since(4) = %q, missed %d; want none, missed 1", messages, missed)
+	}
+}
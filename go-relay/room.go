@@ -0,0 +1,97 @@
+// Package main provides rooms for running several robots on one relay.
+//
+// Every client joins a room when it connects: a Python client joins the room
+// named after its robot, and operators join the room of the robot they want
+// to drive. Routing never crosses rooms, and each room has its own control
+// lease, emergency stop latch and ramp filter, so operators of different
+// robots don't interfere with each other. Clients that don't name a room
+// join DefaultRoom.
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultRoom is the room used by clients that don't select a robot.
+const DefaultRoom = "default"
+
+// maxRoomLength bounds the length of a room identifier.
+const maxRoomLength = 64
+
+// ParseRoom validates a client-supplied room identifier.
+// An empty identifier selects DefaultRoom.
+func ParseRoom(id string) (string, error) {
+	if id == "" {
+		return DefaultRoom, nil
+	}
+	if len(id) > maxRoomLength {
+		return "", fmt.Errorf("room id longer than %d characters", maxRoomLength)
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return "", fmt.Errorf("invalid character %q in room id", c)
+		}
+	}
+	return id, nil
+}
+
+// Room holds the command state for one robot.
+type Room struct {
+	ID    string
+	ramp  *RampFilter   // Acceleration limiter (nil if disabled)
+	lease *ControlLease // Exclusive control lease (nil if disabled)
+	estop *EStop        // Latching emergency stop (nil if disabled)
+}
+
+// Stop terminates the room's background loops.
+func (r *Room) Stop() {
+	if r.ramp != nil {
+		r.ramp.Stop()
+	}
+	if r.lease != nil {
+		r.lease.Stop()
+	}
+	if r.estop != nil {
+		r.estop.Stop()
+	}
+}
+
+// RoomCount holds the number of clients of each type in a room.
+type RoomCount struct {
+	Web    int
+	Python int
+}
+
+// RobotInfo describes an online robot for the /robots endpoint.
+type RobotInfo struct {
+	Room          string `json:"room"`          // Room / robot identifier
+	Robots        int    `json:"robots"`        // Connected Python clients
+	Operators     int    `json:"operators"`     // Connected web clients
+	ControlHolder string `json:"controlHolder"` // ID of the client holding control ("" if free)
+	EStopEngaged  bool   `json:"estopEngaged"`  // Whether the room's e-stop is latched
+}
+
+// mergeRoomCounts adds the counts in src to dst.
+func mergeRoomCounts(dst, src map[string]RoomCount) {
+	for room, count := range src {
+		total := dst[room]
+		total.Web += count.Web
+		total.Python += count.Python
+		dst[room] = total
+	}
+}
+
+// sortedRooms returns the room ids in counts in sorted order.
+func sortedRooms(counts map[string]RoomCount) []string {
+	rooms := make([]string, 0, len(counts))
+	for room := range counts {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestParseRoom(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+		ok   bool
+	}{
+		{"", DefaultRoom, true},
+		{"robot1", "robot1", true},
+		{"Lab-2_arm.left", "Lab-2_arm.left", true},
+		{strings.Repeat("a", maxRoomLength), strings.Repeat("a", maxRoomLength), true},
+		{strings.Repeat("a", maxRoomLength+1), "", false},
+		{"robot 1", "", false},
+		{"robot/1", "", false},
+		{"../etc", "", false},
+		{"robot?x=1", "", false},
+		{"roböt", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got, err := ParseRoom(tt.id)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseRoom(%q) error = %v, want ok = %v", tt.id, err, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRoom(%q) = %q, want %q", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRoomCounts(t *testing.T) {
+	counts := map[string]RoomCount{
+		"robot1": {Web: 2, Python: 1},
+		"robot2": {Web: 1},
+	}
+	mergeRoomCounts(counts, map[string]RoomCount{
+		"robot2": {Web: 1, Python: 1},
+		"robot3": {Python: 2},
+	})
+
+	want := map[string]RoomCount{
+		"robot1": {Web: 2, Python: 1},
+		"robot2": {Web: 2, Python: 1},
+		"robot3": {Python: 2},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("mergeRoomCounts() = %v, want %v", counts, want)
+	}
+	if rooms := sortedRooms(counts); !reflect.DeepEqual(rooms, []string{"robot1", "robot2", "robot3"}) {
+		t.Errorf("sortedRooms() = %v, want [robot1 robot2 robot3]", rooms)
+	}
+}
+
+func TestRouterRoomCount(t *testing.T) {
+	m := NewWSManager(nil, nil)
+	for _, client := range []*WSClient{
+		{ID: "op1", PeerType: "web", Room: "robot1"},
+		{ID: "op2", PeerType: "web", Room: "robot2"},
+		{ID: "bot1", PeerType: "python", Room: "robot1"},
+	} {
+		m.dataClients[client.ID] = client
+		addRoomClient(m.dataRooms, client)
+	}
+	detachedClient(m, PeerTypePython) // Resuming, so not counted
+
+	mr := NewMessageRouter(NewPeerManager(webrtc.Configuration{}))
+	mr.SetWSManager(m)
+
+	tests := []struct {
+		room string
+		want RoomCount
+	}{
+		{"robot1", RoomCount{Web: 1, Python: 1}},
+		{"robot2", RoomCount{Web: 1}},
+		{DefaultRoom, RoomCount{}},
+		{"empty", RoomCount{}},
+	}
+	for _, tt := range tests {
+		if got := mr.RoomCount(tt.room); got != tt.want {
+			t.Errorf("RoomCount(%s) = %+v, want %+v", tt.room, got, tt.want)
+		}
+	}
+
+	robots := mr.Robots()
+	if len(robots) != 1 || robots[0].Room != "robot1" || robots[0].Robots != 1 || robots[0].Operators != 1 {
+		t.Errorf("Robots() = %+v, want robot1 with one robot and one operator", robots)
+	}
+}
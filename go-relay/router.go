@@ -0,0 +1,1150 @@
+// Package main provides message routing between web and Python clients.
+//
+// MessageRouter receives Twist frames from both transports (WebRTC
+// DataChannels and WebSockets). Operator commands from web clients are passed
+// through the command pipeline before reaching the robot:
+//   - Emergency stop: drop everything while the latch is set
+//   - Stale filter: reject old or reordered commands
+//   - Control lease: only the current holder may drive
+//   - Deadman watchdog: stop the robot if the operator goes silent
+//   - Velocity envelope: clamp or drop out-of-range commands
+//   - Ramp filter: limit acceleration and emit at a fixed rate (optional)
+//
+// Binary frames are either versioned envelopes (dispatched on their message
+// type id) or bare legacy Twists. Frames from Python clients, including
+// telemetry, are fanned out to the web clients in the same room. Routing
+// never crosses rooms, and the e-stop, lease and ramp filter are per room.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRoomNotOpen is returned by actions on a room with no connected clients
+// and no latched e-stop.
+var ErrRoomNotOpen = errors.New("room is not open")
+
+// MessageRouter handles routing of Twist messages between peers.
+type MessageRouter struct {
+	peerManager *PeerManager
+	wsManager   *WSManager // WebSocket manager for cross-protocol routing
+	watchdog    *Watchdog  // Deadman watchdog for web sources (nil if disabled)
+	limits      atomic.Pointer[VelocityLimits]
+	stale       *StaleFilter // Stale/reordered command filter (nil if disabled)
+	sequences   *SequenceTracker
+	telemetry   *TelemetryCache // Latest robot telemetry for newly joined operators
+
+	// Settings for the per-room command state
+	rampLinearAccel  float64       // Max linear acceleration in m/s²
+	rampAngularAccel float64       // Max angular acceleration in rad/s²
+	rampRate         float64       // Ramp output rate in Hz (0 = ramp disabled)
+	leaseTTL         time.Duration // Control lease TTL (0 = lease disabled)
+	autoAcquire      bool          // Grant a free lease on a client's first Twist
+	estopRate        float64       // Stop command rate in Hz (0 = e-stop disabled)
+	estopResetRoles  []string      // Roles allowed to reset the e-stop
+
+	roomsMu sync.Mutex
+	rooms   map[string]*Room // Rooms with connected clients or a latched e-stop
+
+	roomLimitsMu sync.RWMutex
+	roomLimits   map[string]*VelocityLimits // Per-room envelopes replacing limits
+
+	stats routerCounters
+}
+
+// RouterStats tracks message routing statistics.
+type RouterStats struct {
+	MessagesReceived   uint64 `json:"received"`
+	MessagesForwarded  uint64 `json:"forwarded"`
+	ParseErrors        uint64 `json:"errors"`
+	WatchdogTrips      uint64 `json:"watchdog_trips"`
+	LimitsClamped      uint64 `json:"limits_clamped"`      // Commands clamped into the velocity envelope
+	LimitsDropped      uint64 `json:"limits_dropped"`      // Commands dropped for violating the envelope
+	LeaseRejected      uint64 `json:"lease_rejected"`      // Commands dropped from clients without control
+	EStopDropped       uint64 `json:"estop_dropped"`       // Commands dropped while the e-stop was latched
+	StaleDropped       uint64 `json:"stale_dropped"`       // Commands dropped for exceeding the max age
+	StaleZeroed        uint64 `json:"stale_zeroed"`        // Commands replaced by a stop for exceeding the max age
+	Reordered          uint64 `json:"reordered"`           // Commands dropped for going back in time
+	UnknownTypes       uint64 `json:"unknown_types"`       // Frames with an unknown message type
+	SequenceGaps       uint64 `json:"seq_gaps"`            // Framed messages lost in transit
+	SequenceOutOfOrder uint64 `json:"seq_out_of_order"`    // Framed messages received out of order
+	TelemetryForwarded uint64 `json:"telemetry_forwarded"` // Telemetry frames received from robots
+	TelemetryRejected  uint64 `json:"telemetry_rejected"`  // Telemetry frames from web clients, dropped
+	RoleRejected       uint64 `json:"role_rejected"`       // Commands dropped from clients whose role may not drive
+	Oversized          uint64 `json:"oversized"`           // Messages dropped for exceeding their type's size cap
+}
+
+// routerCounters holds the live counters behind RouterStats. They are
+// updated from the pion callbacks, WebSocket pumps, watchdog and room
+// tickers concurrently.
+type routerCounters struct {
+	MessagesReceived   atomic.Uint64
+	MessagesForwarded  atomic.Uint64
+	ParseErrors        atomic.Uint64
+	WatchdogTrips      atomic.Uint64
+	LimitsClamped      atomic.Uint64
+	LimitsDropped      atomic.Uint64
+	LeaseRejected      atomic.Uint64
+	EStopDropped       atomic.Uint64
+	StaleDropped       atomic.Uint64
+	StaleZeroed        atomic.Uint64
+	Reordered          atomic.Uint64
+	UnknownTypes       atomic.Uint64
+	SequenceGaps       atomic.Uint64
+	SequenceOutOfOrder atomic.Uint64
+	TelemetryForwarded atomic.Uint64
+	TelemetryRejected  atomic.Uint64
+	RoleRejected       atomic.Uint64
+	Oversized          atomic.Uint64
+}
+
+// NewMessageRouter creates a new message router.
+func NewMessageRouter(pm *PeerManager) *MessageRouter {
+	return &MessageRouter{
+		peerManager: pm,
+		sequences:   NewSequenceTracker(),
+		telemetry:   NewTelemetryCache(),
+		rooms:       make(map[string]*Room),
+		roomLimits:  make(map[string]*VelocityLimits),
+	}
+}
+
+// SetWSManager sets the WebSocket manager for cross-protocol routing.
+func (mr *MessageRouter) SetWSManager(wsm *WSManager) {
+	mr.wsManager = wsm
+}
+
+// SetLimits sets the velocity envelope applied to commands from web clients.
+// Safe to call while commands are being routed.
+func (mr *MessageRouter) SetLimits(limits VelocityLimits) {
+	mr.limits.Store(&limits)
+}
+
+// Limits returns the velocity envelope, or false if none is set.
+func (mr *MessageRouter) Limits() (VelocityLimits, bool) {
+	if limits := mr.limits.Load(); limits != nil {
+		return *limits, true
+	}
+	return VelocityLimits{}, false
+}
+
+// SetRoomLimits sets the velocity envelope of one room, replacing the
+// default envelope there. A nil envelope removes the override.
+// Safe to call while commands are being routed.
+func (mr *MessageRouter) SetRoomLimits(roomID string, limits *VelocityLimits) {
+	mr.roomLimitsMu.Lock()
+	defer mr.roomLimitsMu.Unlock()
+
+	if limits == nil {
+		delete(mr.roomLimits, roomID)
+		return
+	}
+	copied := *limits
+	mr.roomLimits[roomID] = &copied
+}
+
+// RoomLimits returns the per-room envelope overrides.
+func (mr *MessageRouter) RoomLimits() LimitsByRoom {
+	mr.roomLimitsMu.RLock()
+	defer mr.roomLimitsMu.RUnlock()
+
+	rooms := make(LimitsByRoom, len(mr.roomLimits))
+	for id, limits := range mr.roomLimits {
+		rooms[id] = *limits
+	}
+	return rooms
+}
+
+// limitsFor returns the envelope that applies in a room, or nil if none.
+func (mr *MessageRouter) limitsFor(roomID string) *VelocityLimits {
+	mr.roomLimitsMu.RLock()
+	limits, ok := mr.roomLimits[roomID]
+	mr.roomLimitsMu.RUnlock()
+
+	if ok {
+		return limits
+	}
+	return mr.limits.Load()
+}
+
+// StartWatchdog enables the deadman watchdog with the given timeout.
+// When a web source goes silent mid-motion, a stop is sent to the Python
+// clients in its room.
+func (mr *MessageRouter) StartWatchdog(timeout time.Duration) {
+	mr.watchdog = NewWatchdog(timeout, mr.handleWatchdogTrip)
+	mr.watchdog.Start()
+}
+
+// EnableRamp enables acceleration limiting of operator commands.
+// Each room gets its own filter, which sends ramped commands to the room's
+// Python clients at rateHz.
+func (mr *MessageRouter) EnableRamp(maxLinearAccel, maxAngularAccel, rateHz float64) {
+	mr.rampLinearAccel = maxLinearAccel
+	mr.rampAngularAccel = maxAngularAccel
+	mr.rampRate = rateHz
+}
+
+// EnableLease enables a per-room exclusive control lease with the given TTL.
+// If autoAcquire is set, a client that sends a Twist while the lease is free
+// is granted control without an explicit request.
+func (mr *MessageRouter) EnableLease(ttl time.Duration, autoAcquire bool) {
+	mr.leaseTTL = ttl
+	mr.autoAcquire = autoAcquire
+}
+
+// EnableEStop enables a per-room latching emergency stop.
+// While latched, stop commands are sent to the room's Python clients at rateHz.
+// If resetRoles is non-empty, only those roles may clear the latch.
+func (mr *MessageRouter) EnableEStop(rateHz float64, resetRoles []string) {
+	mr.estopRate = rateHz
+	mr.estopResetRoles = resetRoles
+}
+
+// SetStaleFilter enables rejection of stale and reordered commands.
+func (mr *MessageRouter) SetStaleFilter(filter *StaleFilter) {
+	mr.stale = filter
+}
+
+// ObservePing records the client timestamp from a ping for clock skew estimation.
+func (mr *MessageRouter) ObservePing(sourceID string, clientMs int64) {
+	if mr.stale != nil {
+		mr.stale.ObservePing(sourceID, clientMs, time.Now())
+	}
+}
+
+// Close stops all background loops started by the router.
+func (mr *MessageRouter) Close() {
+	if mr.watchdog != nil {
+		mr.watchdog.Stop()
+	}
+
+	mr.roomsMu.Lock()
+	defer mr.roomsMu.Unlock()
+	for _, room := range mr.rooms {
+		room.Stop()
+	}
+}
+
+// room returns the command state for a room, creating it on first use.
+func (mr *MessageRouter) room(id string) *Room {
+	mr.roomsMu.Lock()
+	defer mr.roomsMu.Unlock()
+
+	if room, ok := mr.rooms[id]; ok {
+		return room
+	}
+
+	room := &Room{ID: id}
+	if mr.rampRate > 0 {
+		room.ramp = NewRampFilter(mr.rampLinearAccel, mr.rampAngularAccel, mr.rampRate, func(twist *TwistMessage) {
+			mr.broadcastCommand(id, EncodeTwist(twist))
+		})
+		room.ramp.Start()
+	}
+	if mr.leaseTTL > 0 {
+		room.lease = NewControlLease(mr.leaseTTL, func(holderID string) {
+			mr.stopRamp(id)
+			mr.notifyHolder(id)
+		})
+		room.lease.Start()
+	}
+	if mr.estopRate > 0 {
+		room.estop = NewEStop(mr.estopRate, mr.estopResetRoles, func() {
+			mr.broadcastToType(id, PeerTypePython, EncodeTwist(EmergencyStop()))
+		})
+		room.estop.Start()
+	}
+	mr.rooms[id] = room
+
+	log.Printf("[Router] Room %s opened", id)
+	return room
+}
+
+// lookupRoom returns the command state for a room, or nil if it isn't open.
+func (mr *MessageRouter) lookupRoom(id string) *Room {
+	mr.roomsMu.Lock()
+	defer mr.roomsMu.Unlock()
+	return mr.rooms[id]
+}
+
+// closeRoomIfIdle discards a room's command state once its last client has
+// left. Rooms with a latched e-stop are kept so the latch survives a robot
+// reconnecting.
+func (mr *MessageRouter) closeRoomIfIdle(id string) {
+	if len(mr.peerManager.GetRoomPeers(id, PeerTypeWeb)) > 0 || len(mr.peerManager.GetRoomPeers(id, PeerTypePython)) > 0 {
+		return
+	}
+	if mr.wsManager != nil && mr.wsManager.RoomClientCount(id) > 0 {
+		return
+	}
+
+	mr.roomsMu.Lock()
+	defer mr.roomsMu.Unlock()
+
+	room, ok := mr.rooms[id]
+	if !ok || (room.estop != nil && room.estop.Engaged()) {
+		return
+	}
+	room.Stop()
+	delete(mr.rooms, id)
+
+	log.Printf("[Router] Room %s closed", id)
+}
+
+// roomOf returns the room of a connected client, or DefaultRoom if unknown.
+func (mr *MessageRouter) roomOf(id string) string {
+	if peer := mr.peerManager.GetPeer(id); peer != nil {
+		return peer.Room
+	}
+	if mr.wsManager != nil {
+		if client := mr.wsManager.GetDataClient(id); client != nil {
+			return client.Room
+		}
+	}
+	return DefaultRoom
+}
+
+// Robots lists the rooms with at least one connected Python client.
+func (mr *MessageRouter) Robots() []RobotInfo {
+	counts := mr.roomCounts()
+	robots := []RobotInfo{}
+	for _, id := range sortedRooms(counts) {
+		count := counts[id]
+		if count.Python == 0 {
+			continue
+		}
+		robots = append(robots, RobotInfo{
+			Room:          id,
+			Robots:        count.Python,
+			Operators:     count.Web,
+			ControlHolder: mr.ControlHolder(id),
+			EStopEngaged:  mr.EStopState(id).Engaged,
+		})
+	}
+	return robots
+}
+
+// RoomCount returns the number of WebRTC and WebSocket clients of each type
+// in a room.
+func (mr *MessageRouter) RoomCount(roomID string) RoomCount {
+	return mr.roomCounts()[roomID]
+}
+
+// roomCounts returns the WebRTC and WebSocket client counts of every room.
+func (mr *MessageRouter) roomCounts() map[string]RoomCount {
+	counts := mr.peerManager.RoomCounts()
+	if mr.wsManager != nil {
+		mergeRoomCounts(counts, mr.wsManager.RoomCounts())
+	}
+	return counts
+}
+
+// LeaseTTL returns the control lease TTL, or 0 if the lease is disabled.
+func (mr *MessageRouter) LeaseTTL() time.Duration {
+	return mr.leaseTTL
+}
+
+// ControlHolder returns the ID of the client holding control of a room, or
+// "" if the lease is free or disabled.
+func (mr *MessageRouter) ControlHolder(roomID string) string {
+	room := mr.lookupRoom(roomID)
+	if room == nil || room.lease == nil {
+		return ""
+	}
+	return room.lease.Holder()
+}
+
+// IsOperator reports whether a client currently operates a room's robot:
+// its role may drive and it holds the control lease, or leases are disabled.
+func (mr *MessageRouter) IsOperator(roomID, id string) bool {
+	if !mr.identityOf(id).Can(PermDrive) {
+		return false
+	}
+	if mr.leaseTTL == 0 {
+		return true
+	}
+	return mr.ControlHolder(roomID) == id
+}
+
+// EStopState returns the emergency stop latch state of a room.
+func (mr *MessageRouter) EStopState(roomID string) EStopState {
+	room := mr.lookupRoom(roomID)
+	if room == nil || room.estop == nil {
+		return EStopState{}
+	}
+	return room.estop.State()
+}
+
+// EngageEStop latches the emergency stop of an open room on behalf of source.
+func (mr *MessageRouter) EngageEStop(roomID, source string) error {
+	if mr.estopRate <= 0 {
+		return fmt.Errorf("emergency stop is disabled")
+	}
+	room := mr.lookupRoom(roomID)
+	if room == nil {
+		return fmt.Errorf("%w: %s", ErrRoomNotOpen, roomID)
+	}
+
+	// Clear any ramped target so motion doesn't resume after a reset
+	if room.ramp != nil {
+		room.ramp.Reset()
+	}
+
+	if room.estop.Engage(source) {
+		mr.notifyEStop(roomID)
+	}
+	return nil
+}
+
+// ResetEStop clears the emergency stop latch of a room if role is allowed to.
+func (mr *MessageRouter) ResetEStop(roomID, source, role string) error {
+	if mr.estopRate <= 0 {
+		return fmt.Errorf("emergency stop is disabled")
+	}
+	room := mr.lookupRoom(roomID)
+	if room == nil {
+		return fmt.Errorf("%w: %s", ErrRoomNotOpen, roomID)
+	}
+	if !room.estop.CanReset(role) {
+		log.Printf("[Router] E-stop reset in %s by %s denied (role: %s)", roomID, source, role)
+		return fmt.Errorf("role %q may not reset the emergency stop", role)
+	}
+
+	if room.estop.Reset(source) {
+		mr.notifyEStop(roomID)
+	}
+
+	// A room kept open only by its latch can go now
+	mr.closeRoomIfIdle(roomID)
+	return nil
+}
+
+// HandleEStop processes an e-stop action from a client.
+// Any client may engage the latch; reset is subject to the role check.
+func (mr *MessageRouter) HandleEStop(sourceID string, sourceType PeerType, action string) {
+	roomID := mr.roomOf(sourceID)
+	mr.room(roomID)
+
+	var err error
+	switch action {
+	case EStopActionEngage:
+		err = mr.EngageEStop(roomID, sourceID)
+	case EStopActionReset:
+		err = mr.ResetEStop(roomID, sourceID, mr.roleOf(sourceID, sourceType))
+	default:
+		log.Printf("[Router] Unknown e-stop action %q from %s", action, sourceID)
+		return
+	}
+
+	// Let the requester know the request didn't take effect
+	if err != nil {
+		mr.sendTo(sourceID, mr.estopMessage(roomID).Encode())
+	}
+}
+
+// estopMessage builds the e-stop state message for a room.
+func (mr *MessageRouter) estopMessage(roomID string) *EStopMessage {
+	state := mr.EStopState(roomID)
+	return &EStopMessage{
+		Type:      "estop",
+		Action:    EStopActionState,
+		Engaged:   state.Engaged,
+		Source:    state.Source,
+		Since:     state.Since,
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+// notifyEStop pushes a room's latch state to every client in the room.
+func (mr *MessageRouter) notifyEStop(roomID string) {
+	data := mr.estopMessage(roomID).Encode()
+	mr.broadcastText(roomID, PeerTypeWeb, data)
+	mr.broadcastText(roomID, PeerTypePython, data)
+}
+
+// HandleControl processes a control lease action from a client.
+func (mr *MessageRouter) HandleControl(sourceID string, sourceType PeerType, action string) {
+	if mr.leaseTTL <= 0 {
+		return
+	}
+	if sourceType != PeerTypeWeb {
+		log.Printf("[Router] Ignoring control %q from non-web client %s", action, sourceID)
+		return
+	}
+
+	roomID := mr.roomOf(sourceID)
+	lease := mr.room(roomID).lease
+
+	switch action {
+	case ControlActionRequest:
+		if !mr.identityOf(sourceID).Can(PermDrive) {
+			log.Printf("[Router] Control of %s denied to %s (role may not drive)", roomID, sourceID)
+			reply := ControlMessage{Type: "control", Action: ControlActionDenied, HolderID: lease.Holder(),
+				TTLMs: lease.TTL().Milliseconds(), Timestamp: time.Now().UnixMilli()}
+			mr.sendTo(sourceID, reply.Encode())
+			return
+		}
+
+		holder, ok := lease.Acquire(sourceID)
+		reply := ControlMessage{Type: "control", Action: ControlActionDenied, HolderID: holder,
+			TTLMs: lease.TTL().Milliseconds(), Timestamp: time.Now().UnixMilli()}
+		if ok {
+			reply.Action = ControlActionGranted
+			log.Printf("[Router] Control of %s granted to %s", roomID, sourceID)
+		} else {
+			log.Printf("[Router] Control of %s denied to %s (held by %s)", roomID, sourceID, holder)
+		}
+		mr.sendTo(sourceID, reply.Encode())
+		if ok {
+			mr.notifyHolder(roomID)
+		}
+
+	case ControlActionRelease:
+		if lease.Release(sourceID) {
+			log.Printf("[Router] Control of %s released by %s", roomID, sourceID)
+			mr.stopRamp(roomID)
+			mr.notifyHolder(roomID)
+		}
+
+	default:
+		log.Printf("[Router] Unknown control action %q from %s", action, sourceID)
+	}
+}
+
+// ForceControl hands a room's lease to a web client in that room, or revokes
+// it if id is empty. Used by administrators to force a handover. Revoking
+// the lease of a room that isn't open is a no-op.
+func (mr *MessageRouter) ForceControl(roomID, id string) error {
+	if mr.leaseTTL <= 0 {
+		return fmt.Errorf("control lease is disabled")
+	}
+	if id != "" && (!mr.isWebClient(id) || mr.roomOf(id) != roomID) {
+		return fmt.Errorf("no connected web client %s in room %s", id, roomID)
+	}
+	if id != "" && !mr.identityOf(id).Can(PermDrive) {
+		return fmt.Errorf("client %s may not drive", id)
+	}
+
+	room := mr.lookupRoom(roomID)
+	if room == nil {
+		if id == "" {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrRoomNotOpen, roomID)
+	}
+
+	prev := room.lease.Force(id)
+	log.Printf("[Router] Control of %s forced from %q to %q", roomID, prev, id)
+	if prev != id {
+		mr.stopRamp(roomID)
+	}
+	mr.notifyHolder(roomID)
+	mr.closeRoomIfIdle(roomID)
+	return nil
+}
+
+// Kick disconnects a client on either transport. Kicked clients can't
+// resume their session.
+func (mr *MessageRouter) Kick(id string) error {
+	if mr.peerManager.GetPeer(id) != nil {
+		log.Printf("[Router] Kicking peer %s", id)
+		mr.peerManager.RemovePeer(id)
+		return nil
+	}
+	if mr.wsManager != nil && mr.wsManager.Kick(id) {
+		log.Printf("[Router] Kicked WebSocket client %s", id)
+		return nil
+	}
+	return fmt.Errorf("no connected client %s", id)
+}
+
+// HandleJoin brings a newly connected client up to date.
+// Web clients receive the room's cached robot telemetry, e-stop state and
+// current control holder.
+func (mr *MessageRouter) HandleJoin(sourceID string, sourceType PeerType, roomID string) {
+	mr.room(roomID)
+	if sourceType != PeerTypeWeb {
+		return
+	}
+
+	frames := mr.telemetry.Snapshot(roomID)
+	for _, frame := range frames {
+		mr.sendFrameTo(sourceID, frame)
+	}
+
+	if mr.estopRate > 0 {
+		mr.sendTo(sourceID, mr.estopMessage(roomID).Encode())
+	}
+	if mr.leaseTTL > 0 {
+		mr.sendTo(sourceID, mr.holderMessage(roomID).Encode())
+	}
+
+	log.Printf("[Router] Sent %d cached telemetry frame(s) to %s", len(frames), sourceID)
+}
+
+// HandleDisconnect releases any state held by a client that went away.
+// The watchdog keeps tracking the client so a stop is still injected if it
+// disconnected mid-motion.
+func (mr *MessageRouter) HandleDisconnect(sourceID, roomID string) {
+	mr.sequences.Remove(sourceID)
+	mr.telemetry.RemoveSource(sourceID)
+	if mr.stale != nil {
+		mr.stale.Remove(sourceID)
+	}
+
+	if room := mr.lookupRoom(roomID); room != nil && room.lease != nil && room.lease.Release(sourceID) {
+		log.Printf("[Router] Control of %s released by disconnected client %s", roomID, sourceID)
+		mr.stopRamp(roomID)
+		mr.notifyHolder(roomID)
+	}
+	mr.closeRoomIfIdle(roomID)
+}
+
+// HandleSuspend keeps a suspended client's control lease alive for the
+// grace period in which it may resume. The watchdog still stops the robot
+// once its commands stop arriving.
+func (mr *MessageRouter) HandleSuspend(sourceID, roomID string, grace time.Duration) {
+	if room := mr.lookupRoom(roomID); room != nil && room.lease != nil && room.lease.Extend(sourceID, grace) {
+		log.Printf("[Router] Control of %s held for suspended client %s", roomID, sourceID)
+	}
+}
+
+// checkLease reports whether sourceID may drive in a room, renewing its lease.
+func (mr *MessageRouter) checkLease(room *Room, sourceID string) bool {
+	if room.lease == nil || room.lease.Renew(sourceID) {
+		return true
+	}
+	if mr.autoAcquire {
+		if _, ok := room.lease.Acquire(sourceID); ok {
+			log.Printf("[Router] Control of %s auto-granted to %s", room.ID, sourceID)
+			mr.notifyHolder(room.ID)
+			return true
+		}
+	}
+	return false
+}
+
+// stopRamp drops a room's ramped target when its holder loses control, and
+// stops the robot if the filter was still driving it.
+func (mr *MessageRouter) stopRamp(roomID string) {
+	room := mr.lookupRoom(roomID)
+	if room == nil || room.ramp == nil || !room.ramp.Reset() {
+		return
+	}
+	sent := mr.broadcastToType(roomID, PeerTypePython, EncodeTwist(EmergencyStop()))
+	log.Printf("[Router] Ramp stopped in %s, stop sent to %d Python client(s)", roomID, sent)
+}
+
+// holderMessage builds the control holder message for a room.
+func (mr *MessageRouter) holderMessage(roomID string) *ControlMessage {
+	return &ControlMessage{
+		Type:      "control",
+		Action:    ControlActionHolder,
+		HolderID:  mr.ControlHolder(roomID),
+		TTLMs:     mr.leaseTTL.Milliseconds(),
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+// notifyHolder tells all web clients in a room who currently holds control.
+func (mr *MessageRouter) notifyHolder(roomID string) {
+	mr.broadcastText(roomID, PeerTypeWeb, mr.holderMessage(roomID).Encode())
+}
+
+// isWebClient reports whether id is a connected web client on either transport.
+func (mr *MessageRouter) isWebClient(id string) bool {
+	if peer := mr.peerManager.GetPeer(id); peer != nil {
+		return peer.Type == PeerTypeWeb
+	}
+	if mr.wsManager != nil {
+		if client := mr.wsManager.GetDataClient(id); client != nil {
+			return client.PeerType == string(PeerTypeWeb)
+		}
+	}
+	return false
+}
+
+// identityOf returns the authenticated identity of a connected client on
+// either transport, or nil without authentication.
+func (mr *MessageRouter) identityOf(id string) *Identity {
+	if peer := mr.peerManager.GetPeer(id); peer != nil {
+		return peer.Identity
+	}
+	if mr.wsManager != nil {
+		if client := mr.wsManager.GetDataClient(id); client != nil {
+			return client.Identity
+		}
+	}
+	return nil
+}
+
+// roleOf returns the role used for e-stop reset checks: the client's
+// authenticated role, or its peer type without authentication.
+func (mr *MessageRouter) roleOf(id string, peerType PeerType) string {
+	if identity := mr.identityOf(id); identity != nil {
+		return string(identity.Role)
+	}
+	return string(peerType)
+}
+
+// mayDrive reports whether a client's role allows commands, counting drops.
+func (mr *MessageRouter) mayDrive(sourceID string) bool {
+	if mr.identityOf(sourceID).Can(PermDrive) {
+		return true
+	}
+	mr.stats.RoleRejected.Add(1)
+	return false
+}
+
+// sendTo sends a text message to a single client on whichever transport it uses.
+func (mr *MessageRouter) sendTo(id string, data []byte) {
+	if mr.peerManager.GetPeer(id) != nil {
+		if err := mr.peerManager.SendTextToPeer(id, data); err != nil {
+			log.Printf("[Router] Failed to send to %s: %v", id, err)
+		}
+		return
+	}
+	if mr.wsManager != nil {
+		if err := mr.wsManager.SendToDataClient(id, data); err != nil {
+			log.Printf("[Router] Failed to send to %s: %v", id, err)
+		}
+	}
+}
+
+// sendFrameTo sends a binary frame to a single client on whichever transport it uses.
+func (mr *MessageRouter) sendFrameTo(id string, data []byte) {
+	if mr.peerManager.GetPeer(id) != nil {
+		if err := mr.peerManager.SendToPeer(id, data); err != nil {
+			log.Printf("[Router] Failed to send to %s: %v", id, err)
+		}
+		return
+	}
+	if mr.wsManager != nil {
+		if err := mr.wsManager.SendToDataClient(id, data); err != nil {
+			log.Printf("[Router] Failed to send to %s: %v", id, err)
+		}
+	}
+}
+
+// broadcastText sends a text message to all clients of the given type in a
+// room over both transports. Not counted as forwarded traffic.
+func (mr *MessageRouter) broadcastText(roomID string, peerType PeerType, data []byte) int {
+	sent := mr.peerManager.BroadcastTextToType(roomID, peerType, data)
+	if mr.wsManager != nil {
+		sent += mr.wsManager.BroadcastToType(roomID, string(peerType), data)
+	}
+	return sent
+}
+
+// ObserveTwist records a Twist from a web source for the deadman watchdog.
+func (mr *MessageRouter) ObserveTwist(sourceID, roomID string, twist *TwistMessage) {
+	if mr.watchdog != nil {
+		mr.watchdog.Touch(sourceID, roomID, twist)
+	}
+}
+
+// FilterCommand applies a room's velocity envelope to a Twist from a web
+// source. Returns the command to forward (a clamped copy if it was out of
+// range) and false if the command must be dropped.
+func (mr *MessageRouter) FilterCommand(sourceID, roomID string, twist *TwistMessage) (*TwistMessage, bool) {
+	limits := mr.limitsFor(roomID)
+	if limits == nil {
+		return twist, true
+	}
+
+	out, violated, ok := limits.Apply(twist)
+	if !violated {
+		return twist, true
+	}
+	if !ok {
+		mr.stats.LimitsDropped.Add(1)
+		log.Printf("[Router] Dropped out-of-envelope Twist from %s: %s", sourceID, twist.String())
+		return nil, false
+	}
+
+	mr.stats.LimitsClamped.Add(1)
+	log.Printf("[Router] Clamped Twist from %s to %s", sourceID, out.String())
+	return out, true
+}
+
+// RouteCommand runs a Twist from a web source through the command pipeline
+// and forwards it to the Python clients in the source's room over both
+// transports.
+// Returns the number of clients the command was sent to directly; commands
+// handed to the ramp filter are emitted later and return 0.
+func (mr *MessageRouter) RouteCommand(sourceID string, twist *TwistMessage, data []byte) int {
+	return mr.routeCommand(sourceID, mr.room(mr.roomOf(sourceID)), twist, data, EncodeTwist)
+}
+
+// routeCommand implements RouteCommand for any message carrying a Twist.
+// encode re-encodes the message when the pipeline changes the command.
+func (mr *MessageRouter) routeCommand(sourceID string, room *Room, twist *TwistMessage, data []byte, encode func(*TwistMessage) []byte) int {
+	if !mr.mayDrive(sourceID) {
+		return 0
+	}
+	if mr.estopEngaged(room) {
+		return 0
+	}
+
+	if mr.stale != nil {
+		switch mr.stale.Check(sourceID, twist, time.Now()) {
+		case StaleReordered:
+			mr.stats.Reordered.Add(1)
+			log.Printf("[Router] Dropped reordered Twist from %s (ts: %d)", sourceID, twist.Timestamp)
+			return 0
+		case StaleDrop:
+			mr.stats.StaleDropped.Add(1)
+			log.Printf("[Router] Dropped stale Twist from %s (age: %dms)", sourceID, twist.GetLatencyMs())
+			return 0
+		case StaleZero:
+			mr.stats.StaleZeroed.Add(1)
+			log.Printf("[Router] Replaced stale Twist from %s with stop (age: %dms)", sourceID, twist.GetLatencyMs())
+			twist = EmergencyStop()
+			data = encode(twist)
+		}
+	}
+
+	if !mr.checkLease(room, sourceID) {
+		mr.stats.LeaseRejected.Add(1)
+		return 0
+	}
+
+	mr.ObserveTwist(sourceID, room.ID, twist)
+
+	cmd, ok := mr.FilterCommand(sourceID, room.ID, twist)
+	if !ok {
+		return 0
+	}
+
+	if room.ramp != nil {
+		room.ramp.Submit(cmd)
+		return 0
+	}
+
+	if cmd != twist {
+		data = encode(cmd)
+	}
+
+	sent := mr.broadcastCommand(room.ID, data)
+	if sent > 0 {
+		log.Printf("[Router] Forwarded to %d Python client(s) in %s", sent, room.ID)
+	}
+	return sent
+}
+
+// estopEngaged reports whether commands must be dropped because the room's
+// emergency stop is latched, counting the drop.
+func (mr *MessageRouter) estopEngaged(room *Room) bool {
+	if room.estop != nil && room.estop.Engaged() {
+		mr.stats.EStopDropped.Add(1)
+		return true
+	}
+	return false
+}
+
+// handleWatchdogTrip broadcasts an emergency stop on behalf of a silent source.
+func (mr *MessageRouter) handleWatchdogTrip(sourceID, roomID string) {
+	mr.stats.WatchdogTrips.Add(1)
+
+	// Don't let the ramp filter keep replaying the stale target
+	if room := mr.lookupRoom(roomID); room != nil && room.ramp != nil {
+		room.ramp.Reset()
+	}
+
+	sent := mr.broadcastToType(roomID, PeerTypePython, EncodeTwist(EmergencyStop()))
+	log.Printf("[Router] Watchdog stop for %s sent to %d Python client(s) in %s", sourceID, sent, roomID)
+}
+
+// broadcastToType sends data to all clients of the given type in a room over
+// both WebRTC and WebSocket. Returns the number of clients that received it.
+func (mr *MessageRouter) broadcastToType(roomID string, peerType PeerType, data []byte) int {
+	sent := mr.peerManager.BroadcastToType(roomID, peerType, data)
+	if mr.wsManager != nil {
+		sent += mr.wsManager.BroadcastToType(roomID, string(peerType), data)
+	}
+	mr.stats.MessagesForwarded.Add(uint64(sent))
+	return sent
+}
+
+// broadcastCommand sends a streamed motion command to the Python clients in a
+// room, using the low-latency command channel of WebRTC peers that have one.
+// One-off stops go through broadcastToType so they are delivered reliably.
+func (mr *MessageRouter) broadcastCommand(roomID string, data []byte) int {
+	sent := mr.peerManager.BroadcastCommandToType(roomID, PeerTypePython, data)
+	if mr.wsManager != nil {
+		sent += mr.wsManager.BroadcastToType(roomID, string(PeerTypePython), data)
+	}
+	mr.stats.MessagesForwarded.Add(uint64(sent))
+	return sent
+}
+
+// HandleMessage processes an incoming DataChannel message.
+// JSON messages are handled directly; binary frames are routed by type.
+func (mr *MessageRouter) HandleMessage(from *Peer, data []byte) {
+	mr.stats.MessagesReceived.Add(1)
+
+	// JSON text frames carry control, e-stop, ping and JSON-form messages
+	if len(data) > 0 && data[0] == '{' {
+		var msg DataMessage
+		if err := json.Unmarshal(data, &msg); err == nil {
+			switch msg.Type {
+			case "control":
+				mr.HandleControl(from.ID, from.Type, msg.Action)
+				return
+			case "estop":
+				mr.HandleEStop(from.ID, from.Type, msg.Action)
+				return
+			case "message":
+				mr.HandleJSONMessage(from.ID, from.Type, msg.MsgType, msg.Payload)
+				return
+			case "answer":
+				if err := mr.peerManager.AcceptRenegotiation(from, msg.SDP); err != nil {
+					log.Printf("[Router] Renegotiation with %s failed: %v", from.ID, err)
+				}
+				return
+			case "ping":
+				mr.ObservePing(from.ID, msg.Timestamp)
+				pong := DataMessage{Type: "pong", PeerID: from.ID, Timestamp: time.Now().UnixMilli()}
+				pongBytes, _ := json.Marshal(pong)
+				mr.peerManager.SendTextToPeer(from.ID, pongBytes)
+				return
+			}
+		}
+	}
+
+	mr.handleFrame(from.ID, from.Type, data)
+}
+
+// HandleFrame processes a binary frame from a WebSocket client.
+func (mr *MessageRouter) HandleFrame(sourceID string, sourceType PeerType, data []byte) {
+	mr.stats.MessagesReceived.Add(1)
+	mr.handleFrame(sourceID, sourceType, data)
+}
+
+// HandleJSONMessage processes a registered message in its JSON form, e.g.
+// {"type": "message", "msg_type": "std_msgs/Bool", "payload": {"data": true}}.
+// The message is converted to its binary form and routed like a frame.
+func (mr *MessageRouter) HandleJSONMessage(sourceID string, sourceType PeerType, name string, payload json.RawMessage) {
+	info, ok := LookupMessageName(name)
+	if !ok {
+		log.Printf("[Router] Unknown message type %q from %s", name, sourceID)
+		mr.stats.UnknownTypes.Add(1)
+		return
+	}
+
+	msg := info.New()
+	if err := json.Unmarshal(payload, msg); err != nil {
+		log.Printf("[Router] Invalid %s from %s: %v", info.Name, sourceID, err)
+		mr.stats.ParseErrors.Add(1)
+		return
+	}
+
+	bin, err := msg.MarshalBinary()
+	if err != nil {
+		log.Printf("[Router] Failed to encode %s from %s: %v", info.Name, sourceID, err)
+		mr.stats.ParseErrors.Add(1)
+		return
+	}
+
+	mr.routeEnvelope(sourceID, sourceType, &Envelope{Version: EnvelopeVersion, Type: info.Type, Payload: bin}, nil)
+}
+
+// handleFrame decodes a framed or legacy binary message and routes it.
+func (mr *MessageRouter) handleFrame(sourceID string, sourceType PeerType, data []byte) {
+	env, framed, err := mr.decodeFrame(sourceID, data)
+	if err != nil {
+		log.Printf("[Router] Invalid frame from %s (%d bytes): %v", sourceID, len(data), err)
+		mr.stats.ParseErrors.Add(1)
+		return
+	}
+
+	if !framed {
+		data = nil
+	}
+	mr.routeEnvelope(sourceID, sourceType, env, data)
+}
+
+// decodeFrame unwraps a framed message, recording its sequence number.
+// Bare 48/56-byte frames from legacy clients are returned as Twist
+// envelopes with framed set to false.
+func (mr *MessageRouter) decodeFrame(sourceID string, data []byte) (env *Envelope, framed bool, err error) {
+	isLegacy := len(data) == TwistMessageSize || len(data) == TwistMessageSizeLegacy
+
+	if IsEnvelope(data) {
+		env, err := DecodeEnvelope(data)
+		if err == nil {
+			mr.trackSequence(sourceID, env.Seq)
+			return env, true, nil
+		}
+		// A legacy Twist may happen to start with the magic byte
+		if !isLegacy {
+			return nil, false, err
+		}
+	}
+
+	if isLegacy {
+		return &Envelope{Type: MsgTypeTwist, Payload: data}, false, nil
+	}
+	return nil, false, fmt.Errorf("%w: %d bytes", ErrInvalidMessageSize, len(data))
+}
+
+// trackSequence records a sender's sequence number and counts lost frames.
+func (mr *MessageRouter) trackSequence(sourceID string, seq uint32) {
+	gap, inOrder := mr.sequences.Observe(sourceID, seq)
+	if !inOrder {
+		mr.stats.SequenceOutOfOrder.Add(1)
+		return
+	}
+	if gap > 0 {
+		mr.stats.SequenceGaps.Add(uint64(gap))
+		log.Printf("[Router] %d frame(s) lost from %s before seq %d", gap, sourceID, seq)
+	}
+}
+
+// routeEnvelope dispatches a decoded message on its type id.
+// frame holds the original framed bytes, or nil if the message arrived as a
+// legacy Twist or in JSON form.
+func (mr *MessageRouter) routeEnvelope(sourceID string, sourceType PeerType, env *Envelope, frame []byte) {
+	if info, ok := LookupMessage(env.Type); ok && info.MaxSize > 0 && len(env.Payload) > info.MaxSize {
+		log.Printf("[Router] Oversized %s from %s: %d bytes (max %d)", info.Name, sourceID, len(env.Payload), info.MaxSize)
+		mr.stats.Oversized.Add(1)
+		return
+	}
+
+	if env.Type == MsgTypeTwist {
+		twist, err := DecodeTwist(env.Payload)
+		if err != nil {
+			log.Printf("[Router] Invalid Twist from %s: %v", sourceID, err)
+			mr.stats.ParseErrors.Add(1)
+			return
+		}
+		mr.routeTwist(sourceID, sourceType, mr.roomOf(sourceID), twist, env.Payload)
+		return
+	}
+
+	msg, err := DecodeMessage(env.Type, env.Payload)
+	if errors.Is(err, ErrUnknownMessageType) {
+		log.Printf("[Router] Unknown message type %d from %s", env.Type, sourceID)
+		mr.stats.UnknownTypes.Add(1)
+		return
+	}
+	if err != nil {
+		log.Printf("[Router] Invalid message from %s: %v", sourceID, err)
+		mr.stats.ParseErrors.Add(1)
+		return
+	}
+
+	if frame == nil {
+		frame = EncodeEnvelope(env.Type, env.Flags, env.Seq, env.Payload)
+	}
+	mr.routeMessage(sourceID, sourceType, mr.roomOf(sourceID), env, msg, frame)
+}
+
+// routeTwist routes a decoded Twist within a room based on the source peer type.
+// data is the bare Twist encoding; envelopes are not forwarded so legacy
+// receivers keep working.
+func (mr *MessageRouter) routeTwist(sourceID string, sourceType PeerType, roomID string, twist *TwistMessage, data []byte) {
+	if !twist.IsZero() {
+		log.Printf("[Router] Twist from %s: %s", sourceID, twist.String())
+	}
+
+	switch sourceType {
+	case PeerTypeWeb:
+		mr.routeCommand(sourceID, mr.room(roomID), twist, data, EncodeTwist)
+
+	case PeerTypePython:
+		// Forward to all web clients in the room (WebRTC and WebSocket)
+		sent := mr.broadcastToType(roomID, PeerTypeWeb, data)
+		if sent > 0 {
+			log.Printf("[Router] Forwarded to %d web client(s)", sent)
+		}
+	}
+}
+
+// routeMessage routes a registered non-Twist message as a framed envelope.
+// TwistStamped commands go through the full command pipeline; other
+// operator messages (Joy, gripper, goals) are subject to the e-stop and
+// control lease only. Telemetry is only accepted from Python clients and
+// is cached for operators who join later.
+func (mr *MessageRouter) routeMessage(sourceID string, sourceType PeerType, roomID string, env *Envelope, msg Message, frame []byte) {
+	info, _ := LookupMessage(env.Type)
+
+	switch sourceType {
+	case PeerTypeWeb:
+		if info.Telemetry {
+			log.Printf("[Router] Dropped %s from web client %s", env.Type, sourceID)
+			mr.stats.TelemetryRejected.Add(1)
+			return
+		}
+
+		room := mr.room(roomID)
+		if stamped, ok := msg.(*TwistStampedMessage); ok {
+			mr.routeCommand(sourceID, room, &stamped.Twist, frame, func(twist *TwistMessage) []byte {
+				out := &TwistStampedMessage{Header: stamped.Header, Twist: *twist}
+				payload, _ := out.MarshalBinary()
+				return EncodeEnvelope(MsgTypeTwistStamped, env.Flags, env.Seq, payload)
+			})
+			return
+		}
+
+		if !mr.mayDrive(sourceID) {
+			return
+		}
+		if mr.estopEngaged(room) {
+			return
+		}
+		if !mr.checkLease(room, sourceID) {
+			mr.stats.LeaseRejected.Add(1)
+			return
+		}
+
+		sent := mr.broadcastToType(roomID, PeerTypePython, frame)
+		log.Printf("[Router] %s from %s forwarded to %d Python client(s)", env.Type, sourceID, sent)
+
+	case PeerTypePython:
+		if info.Telemetry {
+			mr.stats.TelemetryForwarded.Add(1)
+			mr.telemetry.Store(sourceID, roomID, env.Type, msg, frame)
+		}
+
+		sent := mr.broadcastToType(roomID, PeerTypeWeb, frame)
+		if sent > 0 && !info.Telemetry {
+			log.Printf("[Router] %s forwarded to %d web client(s)", env.Type, sent)
+		}
+	}
+}
+
+// SequenceStats returns per-sender sequence counters for connected clients.
+func (mr *MessageRouter) SequenceStats() map[string]SequenceStats {
+	return mr.sequences.Snapshot()
+}
+
+// GetStats returns current routing statistics.
+func (mr *MessageRouter) GetStats() RouterStats {
+	return RouterStats{
+		MessagesReceived:   mr.stats.MessagesReceived.Load(),
+		MessagesForwarded:  mr.stats.MessagesForwarded.Load(),
+		ParseErrors:        mr.stats.ParseErrors.Load(),
+		WatchdogTrips:      mr.stats.WatchdogTrips.Load(),
+		LimitsClamped:      mr.stats.LimitsClamped.Load(),
+		LimitsDropped:      mr.stats.LimitsDropped.Load(),
+		LeaseRejected:      mr.stats.LeaseRejected.Load(),
+		EStopDropped:       mr.stats.EStopDropped.Load(),
+		StaleDropped:       mr.stats.StaleDropped.Load(),
+		StaleZeroed:        mr.stats.StaleZeroed.Load(),
+		Reordered:          mr.stats.Reordered.Load(),
+		UnknownTypes:       mr.stats.UnknownTypes.Load(),
+		SequenceGaps:       mr.stats.SequenceGaps.Load(),
+		SequenceOutOfOrder: mr.stats.SequenceOutOfOrder.Load(),
+		TelemetryForwarded: mr.stats.TelemetryForwarded.Load(),
+		TelemetryRejected:  mr.stats.TelemetryRejected.Load(),
+		RoleRejected:       mr.stats.RoleRejected.Load(),
+		Oversized:          mr.stats.Oversized.Load(),
+	}
+}
@@ -0,0 +1,77 @@
+// Package main provides per-sender sequence tracking for framed messages.
+//
+// Every framed message carries a uint32 sequence number that the sender
+// increments by one per frame. Gaps between consecutive sequence numbers are
+// counted as lost frames; numbers at or behind the last one seen are counted
+// as out of order. Wrap-around at 2^32 is handled with serial arithmetic.
+package main
+
+import (
+	"sync"
+)
+
+// SequenceStats holds sequence counters for one sender.
+type SequenceStats struct {
+	Frames     uint64 `json:"frames"`       // Framed messages received
+	Gaps       uint64 `json:"gaps"`         // Frames missing between received sequence numbers
+	OutOfOrder uint64 `json:"out_of_order"` // Frames at or behind the last sequence number
+	LastSeq    uint32 `json:"last_seq"`     // Highest sequence number received
+}
+
+// SequenceTracker tracks sequence numbers per sender.
+// Thread-safe for concurrent access from multiple goroutines.
+type SequenceTracker struct {
+	mu      sync.Mutex
+	sources map[string]*SequenceStats
+}
+
+// NewSequenceTracker creates an empty tracker.
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{
+		sources: make(map[string]*SequenceStats),
+	}
+}
+
+// Observe records a sequence number from a sender.
+// Returns the number of frames missing before seq and whether seq was in order.
+func (t *SequenceTracker) Observe(sourceID string, seq uint32) (gap uint32, inOrder bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	src, ok := t.sources[sourceID]
+	if !ok {
+		t.sources[sourceID] = &SequenceStats{Frames: 1, LastSeq: seq}
+		return 0, true
+	}
+	src.Frames++
+
+	// Serial number arithmetic: a forward distance in [1, 2^31) is new
+	delta := seq - src.LastSeq
+	if delta == 0 || delta >= 1<<31 {
+		src.OutOfOrder++
+		return 0, false
+	}
+
+	src.LastSeq = seq
+	src.Gaps += uint64(delta - 1)
+	return delta - 1, true
+}
+
+// Remove forgets a sender, e.g. after it disconnects.
+func (t *SequenceTracker) Remove(sourceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sources, sourceID)
+}
+
+// Snapshot returns a copy of the counters for all connected senders.
+func (t *SequenceTracker) Snapshot() map[string]SequenceStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make(map[string]SequenceStats, len(t.sources))
+	for id, src := range t.sources {
+		result[id] = *src
+	}
+	return result
+}
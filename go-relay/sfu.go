@@ -0,0 +1,517 @@
+// Package main provides selective forwarding of robot media to operators.
+//
+// Python (robot) peers publish media tracks, e.g. one video track per camera.
+// The SFU forwards each track's RTP packets unchanged to every web peer in the
+// same room through a TrackLocalStaticRTP. Tracks are added to and removed
+// from operators that are already connected by renegotiating over their data
+// channel. Keyframe requests (PLI/FIR) from operators are forwarded to the
+// publishing robot, at most one per track every keyframeRequestInterval.
+//
+// Audio flows both ways. A robot's microphone is just another published
+// track. Operators send push-to-talk audio as Opus; the SFU forwards only the
+// current operator's audio into the room's talkback track, which every robot
+// in the room receives. Nothing is mixed.
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// keyframeRequestInterval is the minimum time between keyframe requests
+// forwarded to a publisher for one track, however many operators ask.
+const keyframeRequestInterval = 500 * time.Millisecond
+
+// audioActiveWindow is how recently a peer must have sent forwarded audio to
+// count as publishing audio.
+const audioActiveWindow = time.Second
+
+// opusFrameTicks is one 20ms Opus frame at the 48kHz RTP clock rate.
+const opusFrameTicks = 960
+
+// publishedTrack is a media track received from a robot and forwarded to
+// the operators in its room.
+type publishedTrack struct {
+	key       string                      // Publisher ID + "/" + track ID
+	room      string                      // Room the publisher joined
+	publisher *Peer                       // Robot peer sending the track
+	remote    *webrtc.TrackRemote         // Track as received from the robot
+	local     *webrtc.TrackLocalStaticRTP // Track as sent to operators
+
+	mu           sync.Mutex
+	lastKeyframe time.Time // Last keyframe request sent upstream
+	firSeq       uint8     // FIR command sequence number
+
+	lastPacket int64 // Unix nanoseconds of the last forwarded packet
+}
+
+// speaker is an operator's audio track.
+type speaker struct {
+	peer          *Peer
+	lastForwarded int64 // Unix nanoseconds of the last packet sent to the robots
+}
+
+// talkback is the audio track carrying operator audio to the robots in a
+// room. Packets from successive speakers are renumbered so the robots see
+// one continuous stream.
+type talkback struct {
+	track *webrtc.TrackLocalStaticRTP
+
+	mu        sync.Mutex
+	source    string // Peer ID of the current speaker
+	started   bool   // A packet has been written
+	seq       uint16 // Last sequence number written
+	ts        uint32 // Last timestamp written
+	seqOffset uint16 // Added to the speaker's sequence numbers
+	tsOffset  uint32 // Added to the speaker's timestamps
+}
+
+// write forwards a packet from sourceID, continuing the sequence numbers and
+// timestamps of the previous speaker.
+func (t *talkback) write(sourceID string, packet *rtp.Packet) error {
+	t.mu.Lock()
+	if sourceID != t.source {
+		t.source = sourceID
+		t.seqOffset, t.tsOffset = 0, 0
+		if t.started {
+			t.seqOffset = t.seq + 1 - packet.SequenceNumber
+			t.tsOffset = t.ts + opusFrameTicks - packet.Timestamp
+		}
+	}
+	packet.SequenceNumber += t.seqOffset
+	packet.Timestamp += t.tsOffset
+	t.seq, t.ts, t.started = packet.SequenceNumber, packet.Timestamp, true
+	t.mu.Unlock()
+
+	return t.track.WriteRTP(packet)
+}
+
+// subscriber is a peer receiving media: a web peer receives the tracks
+// published in its room, a Python peer receives the room's talkback track.
+type subscriber struct {
+	peer    *Peer
+	senders map[string]*webrtc.RTPSender // Senders indexed by track key
+}
+
+// SFUStats contains media forwarding statistics.
+type SFUStats struct {
+	Tracks           int    `json:"tracks"`            // Tracks currently published
+	TracksPublished  uint64 `json:"tracks_published"`  // Tracks published since startup
+	PacketsForwarded uint64 `json:"packets_forwarded"` // RTP packets read from publishers
+	KeyframeRequests uint64 `json:"keyframe_requests"` // PLI/FIR sent to publishers
+	TalkForwarded    uint64 `json:"talk_forwarded"`    // Operator audio packets sent to robots
+	TalkDropped      uint64 `json:"talk_dropped"`      // Operator audio packets from non-operators
+}
+
+// SFU forwards media tracks from Python peers to the web peers in their room,
+// and operator audio to the Python peers.
+type SFU struct {
+	peerManager *PeerManager
+	mu          sync.Mutex                            // Protects the maps below
+	tracks      map[string]map[string]*publishedTrack // Tracks indexed by room, then key
+	subscribers map[string]*subscriber                // Subscribers indexed by peer ID
+	speakers    map[string]*speaker                   // Operator audio indexed by peer ID
+	talkbacks   map[string]*talkback                  // Talkback tracks indexed by room
+	mayTalk     func(room, peerID string) bool        // Whether an operator's audio is forwarded
+
+	tracksPublished  uint64
+	packetsForwarded uint64
+	keyframeRequests uint64
+	talkForwarded    uint64
+	talkDropped      uint64
+}
+
+// NewSFU creates an SFU that renegotiates through the given PeerManager.
+func NewSFU(pm *PeerManager) *SFU {
+	return &SFU{
+		peerManager: pm,
+		tracks:      make(map[string]map[string]*publishedTrack),
+		subscribers: make(map[string]*subscriber),
+		speakers:    make(map[string]*speaker),
+		talkbacks:   make(map[string]*talkback),
+	}
+}
+
+// SetTalkPolicy sets the check deciding whose audio reaches the robots,
+// typically the room's current operator. Without one, all audio is forwarded.
+func (s *SFU) SetTalkPolicy(mayTalk func(room, peerID string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mayTalk = mayTalk
+}
+
+// HandleTrack publishes a track received from a Python peer to the web peers
+// in its room and forwards its packets until the track ends. It is meant to
+// be used as the PeerManager's track handler and blocks while forwarding.
+// Audio from web peers is handed to the room's talkback track instead.
+func (s *SFU) HandleTrack(peer *Peer, remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	if peer.Type != PeerTypePython {
+		if remote.Kind() == webrtc.RTPCodecTypeAudio {
+			s.talk(peer, remote)
+			return
+		}
+		log.Printf("[SFU] Ignoring %s track from web peer %s", remote.Kind(), peer.ID)
+		return
+	}
+
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, remote.ID(), remote.StreamID())
+	if err != nil {
+		log.Printf("[SFU] Failed to create track for %s: %v", peer.ID, err)
+		return
+	}
+
+	track := &publishedTrack{
+		key:       peer.ID + "/" + remote.ID(),
+		room:      peer.Room,
+		publisher: peer,
+		remote:    remote,
+		local:     local,
+	}
+
+	s.mu.Lock()
+	if s.tracks[track.room] == nil {
+		s.tracks[track.room] = make(map[string]*publishedTrack)
+	}
+	s.tracks[track.room][track.key] = track
+	var subs []*subscriber
+	for _, sub := range s.subscribers {
+		if sub.peer.Type == PeerTypeWeb && sub.peer.Room == track.room {
+			subs = append(subs, sub)
+		}
+	}
+	s.mu.Unlock()
+	atomic.AddUint64(&s.tracksPublished, 1)
+
+	log.Printf("[SFU] Peer %s published %s track %s (%s) in %s", peer.ID, remote.Kind(), remote.ID(), remote.Codec().MimeType, track.room)
+
+	for _, sub := range subs {
+		if s.attach(sub, track) {
+			s.renegotiate(sub.peer)
+		}
+	}
+
+	s.forward(track)
+	s.unpublish(track)
+}
+
+// forward copies RTP packets from the robot to the operators until the
+// robot's track ends.
+func (s *SFU) forward(track *publishedTrack) {
+	for {
+		packet, _, err := track.remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		atomic.AddUint64(&s.packetsForwarded, 1)
+		atomic.StoreInt64(&track.lastPacket, time.Now().UnixNano())
+
+		// ErrClosedPipe means no operator is bound to the track yet
+		if err := track.local.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Printf("[SFU] Failed to forward %s: %v", track.key, err)
+			return
+		}
+	}
+}
+
+// unpublish removes an ended track from the room and from its operators.
+func (s *SFU) unpublish(track *publishedTrack) {
+	type removal struct {
+		peer   *Peer
+		sender *webrtc.RTPSender
+	}
+
+	s.mu.Lock()
+	delete(s.tracks[track.room], track.key)
+	if len(s.tracks[track.room]) == 0 {
+		delete(s.tracks, track.room)
+	}
+	var removals []removal
+	for _, sub := range s.subscribers {
+		if sender, ok := sub.senders[track.key]; ok {
+			delete(sub.senders, track.key)
+			removals = append(removals, removal{sub.peer, sender})
+		}
+	}
+	s.mu.Unlock()
+
+	log.Printf("[SFU] Track %s ended", track.key)
+
+	for _, r := range removals {
+		if err := r.peer.Connection.RemoveTrack(r.sender); err != nil {
+			continue // Connection already closed
+		}
+		s.renegotiate(r.peer)
+	}
+}
+
+// talk forwards an operator's audio to the robots in its room while the
+// operator may talk, until the track ends.
+func (s *SFU) talk(peer *Peer, remote *webrtc.TrackRemote) {
+	if !strings.EqualFold(remote.Codec().MimeType, webrtc.MimeTypeOpus) {
+		log.Printf("[SFU] Ignoring %s audio from %s, only Opus is forwarded", remote.Codec().MimeType, peer.ID)
+		return
+	}
+
+	sp := &speaker{peer: peer}
+	s.mu.Lock()
+	s.speakers[peer.ID] = sp
+	s.mu.Unlock()
+	log.Printf("[SFU] Operator %s sends audio in %s", peer.ID, peer.Room)
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.speakers, peer.ID)
+		s.mu.Unlock()
+	}()
+
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		mayTalk := s.mayTalk
+		tb := s.talkbacks[peer.Room]
+		s.mu.Unlock()
+
+		if mayTalk != nil && !mayTalk(peer.Room, peer.ID) {
+			atomic.AddUint64(&s.talkDropped, 1)
+			continue
+		}
+		if tb == nil {
+			continue // No robot in the room
+		}
+
+		if err := tb.write(peer.ID, packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Printf("[SFU] Failed to forward audio from %s: %v", peer.ID, err)
+			return
+		}
+		atomic.AddUint64(&s.talkForwarded, 1)
+		atomic.StoreInt64(&sp.lastForwarded, time.Now().UnixNano())
+	}
+}
+
+// Subscribe adds the tracks published in a web peer's room to its
+// connection, or the room's talkback track to a Python peer's connection.
+// Called once the peer's data channel is open, so the change can be
+// renegotiated over it.
+func (s *SFU) Subscribe(peer *Peer) {
+	sub := &subscriber{
+		peer:    peer,
+		senders: make(map[string]*webrtc.RTPSender),
+	}
+
+	if peer.Type == PeerTypePython {
+		s.subscribeTalkback(sub)
+		return
+	}
+
+	s.mu.Lock()
+	s.subscribers[peer.ID] = sub
+	var tracks []*publishedTrack
+	for _, track := range s.tracks[peer.Room] {
+		tracks = append(tracks, track)
+	}
+	s.mu.Unlock()
+
+	attached := 0
+	for _, track := range tracks {
+		if s.attach(sub, track) {
+			attached++
+		}
+	}
+	if attached > 0 {
+		s.renegotiate(peer)
+	}
+}
+
+// subscribeTalkback adds the room's talkback track to a robot's connection,
+// creating the track for the room's first robot.
+func (s *SFU) subscribeTalkback(sub *subscriber) {
+	room := sub.peer.Room
+
+	s.mu.Lock()
+	tb := s.talkbacks[room]
+	if tb == nil {
+		local, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: 48000,
+			Channels:  2,
+		}, "talkback", "operator")
+		if err != nil {
+			s.mu.Unlock()
+			log.Printf("[SFU] Failed to create talkback track for %s: %v", room, err)
+			return
+		}
+		tb = &talkback{track: local}
+		s.talkbacks[room] = tb
+	}
+	s.subscribers[sub.peer.ID] = sub
+	s.mu.Unlock()
+
+	sender, err := sub.peer.Connection.AddTrack(tb.track)
+	if err != nil {
+		log.Printf("[SFU] Failed to add talkback track to %s: %v", sub.peer.ID, err)
+		return
+	}
+
+	// Keep the RTCP interceptors running; robots send no keyframe requests
+	go func() {
+		for {
+			if _, _, err := sender.ReadRTCP(); err != nil {
+				return
+			}
+		}
+	}()
+
+	s.renegotiate(sub.peer)
+}
+
+// RemovePeer forgets a subscriber, and the room's talkback track with the
+// room's last robot. Tracks published by the peer end on their own when its
+// connection closes.
+func (s *SFU) RemovePeer(peer *Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, peer.ID)
+
+	if peer.Type != PeerTypePython {
+		return
+	}
+	for _, sub := range s.subscribers {
+		if sub.peer.Type == PeerTypePython && sub.peer.Room == peer.Room {
+			return
+		}
+	}
+	delete(s.talkbacks, peer.Room)
+}
+
+// AudioPublishers returns the IDs of the peers in a room whose audio is being
+// forwarded: robots sending microphone audio and the operator talking.
+func (s *SFU) AudioPublishers(room string) []string {
+	since := time.Now().Add(-audioActiveWindow).UnixNano()
+	publishers := []string{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, track := range s.tracks[room] {
+		id := track.publisher.ID
+		if track.remote.Kind() == webrtc.RTPCodecTypeAudio && !seen[id] && atomic.LoadInt64(&track.lastPacket) > since {
+			seen[id] = true
+			publishers = append(publishers, id)
+		}
+	}
+	for id, sp := range s.speakers {
+		if sp.peer.Room == room && !seen[id] && atomic.LoadInt64(&sp.lastForwarded) > since {
+			seen[id] = true
+			publishers = append(publishers, id)
+		}
+	}
+
+	sort.Strings(publishers)
+	return publishers
+}
+
+// attach adds a track to a subscriber's connection and starts relaying its
+// keyframe requests. The caller renegotiates.
+func (s *SFU) attach(sub *subscriber, track *publishedTrack) bool {
+	sender, err := sub.peer.Connection.AddTrack(track.local)
+	if err != nil {
+		log.Printf("[SFU] Failed to add track %s to %s: %v", track.key, sub.peer.ID, err)
+		return false
+	}
+
+	s.mu.Lock()
+	sub.senders[track.key] = sender
+	s.mu.Unlock()
+
+	go s.readRTCP(sender, track)
+	return true
+}
+
+// readRTCP forwards keyframe requests an operator sends for a track to the
+// publishing robot, until the sender is removed.
+func (s *SFU) readRTCP(sender *webrtc.RTPSender, track *publishedTrack) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication:
+				s.requestKeyframe(track, false)
+			case *rtcp.FullIntraRequest:
+				s.requestKeyframe(track, true)
+			}
+		}
+	}
+}
+
+// requestKeyframe sends a PLI, or a FIR if fir is set, for the track to its
+// publisher, unless one was sent within keyframeRequestInterval.
+func (s *SFU) requestKeyframe(track *publishedTrack, fir bool) {
+	track.mu.Lock()
+	if time.Since(track.lastKeyframe) < keyframeRequestInterval {
+		track.mu.Unlock()
+		return
+	}
+	track.lastKeyframe = time.Now()
+
+	ssrc := uint32(track.remote.SSRC())
+	var packet rtcp.Packet = &rtcp.PictureLossIndication{MediaSSRC: ssrc}
+	if fir {
+		track.firSeq++
+		packet = &rtcp.FullIntraRequest{
+			MediaSSRC: ssrc,
+			FIR:       []rtcp.FIREntry{{SSRC: ssrc, SequenceNumber: track.firSeq}},
+		}
+	}
+	track.mu.Unlock()
+
+	if err := track.publisher.Connection.WriteRTCP([]rtcp.Packet{packet}); err != nil {
+		log.Printf("[SFU] Failed to request keyframe for %s: %v", track.key, err)
+		return
+	}
+	atomic.AddUint64(&s.keyframeRequests, 1)
+}
+
+// renegotiate sends a subscriber an updated offer after its tracks changed.
+func (s *SFU) renegotiate(peer *Peer) {
+	if err := s.peerManager.Renegotiate(peer); err != nil {
+		log.Printf("[SFU] Failed to renegotiate with %s: %v", peer.ID, err)
+	}
+}
+
+// GetStats returns media forwarding statistics.
+func (s *SFU) GetStats() SFUStats {
+	s.mu.Lock()
+	tracks := 0
+	for _, room := range s.tracks {
+		tracks += len(room)
+	}
+	s.mu.Unlock()
+
+	return SFUStats{
+		Tracks:           tracks,
+		TracksPublished:  atomic.LoadUint64(&s.tracksPublished),
+		PacketsForwarded: atomic.LoadUint64(&s.packetsForwarded),
+		KeyframeRequests: atomic.LoadUint64(&s.keyframeRequests),
+		TalkForwarded:    atomic.LoadUint64(&s.talkForwarded),
+		TalkDropped:      atomic.LoadUint64(&s.talkDropped),
+	}
+}
@@ -2,23 +2,68 @@
 //
 // Signaling Endpoints:
 //   - POST /offer     - Submit SDP offer, receive SDP answer
-//   - POST /answer    - Submit SDP answer for a peer
+//   - POST /connect   - Ask the relay for an SDP offer (relay as offerer)
+//   - POST /answer    - Submit SDP answer to a relay offer
 //   - POST /ice       - Submit ICE candidate
+//   - GET  /ice       - Poll the relay's ICE candidates (trickle ICE)
+//   - GET  /ice-servers - STUN/TURN servers with time-limited TURN credentials
+//   - GET  /control   - Get the current control lease holder
+//   - POST /control   - Force a control handover (admin)
+//   - GET  /estop     - Get the emergency stop latch state
+//   - POST /estop     - Engage the emergency stop
+//   - POST /estop/reset - Clear the emergency stop latch
+//   - POST /kick      - Disconnect a peer or WebSocket client (admin)
+//   - GET  /config    - Get the runtime configuration
+//   - POST /config    - Change the runtime configuration (admin)
+//   - GET  /robots    - List online robots with their operator counts
 //   - GET  /status    - Get server status and peer count
 //   - GET  /health    - Health check endpoint
+//
+// The control, e-stop and status endpoints act on one room, selected with
+// the "room" query parameter (default: DefaultRoom). Engaging or resetting
+// the e-stop of a room that isn't open returns 404.
+//
+// With an authenticator set, every endpoint except /health requires a
+// bearer token (see auth.go). Peers belong to the identity that created
+// them; /answer and /ice only accept requests from the same identity.
+// Only robots may create Python peers (over mTLS when robot certificates
+// are required, see mtls.go), and the admin endpoints above require the
+// admin role.
+//
+// Browser requests from origins outside the origin policy (see origin.go)
+// are refused with 403 before authentication.
 package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/pion/webrtc/v3"
 )
 
+// answerTimeout is how long a relay-initiated peer waits for POST /answer
+// before it is removed.
+const answerTimeout = 30 * time.Second
+
+// maxConnectVideo caps the "video" receive slots a single /connect may ask
+// for, one per robot camera.
+const maxConnectVideo = 4
+
 // SignalingHandler handles WebRTC signaling over HTTP.
 type SignalingHandler struct {
 	peerManager *PeerManager
+	router      *MessageRouter // Router for control lease and e-stop state
+	iceServers  *ICEServers    // STUN/TURN servers handed to clients
+	sfu         *SFU           // Media forwarding state for /status
+	auth        Authenticator  // Bearer token check (nil disables)
+	origins     *OriginPolicy  // Allowed browser origins (nil allows any)
+	robotCerts  bool           // Python peers need a robot certificate (see mtls.go)
+	limiter     *RateLimiter   // Connection attempt limits for /offer and /connect (nil disables)
 }
 
 // NewSignalingHandler creates a new SignalingHandler with the given PeerManager.
@@ -28,18 +73,92 @@ func NewSignalingHandler(pm *PeerManager) *SignalingHandler {
 	}
 }
 
+// SetRouter sets the message router used for control lease and e-stop requests.
+func (sh *SignalingHandler) SetRouter(router *MessageRouter) {
+	sh.router = router
+}
+
+// SetICEServers sets the STUN/TURN servers returned by /ice-servers.
+func (sh *SignalingHandler) SetICEServers(servers *ICEServers) {
+	sh.iceServers = servers
+}
+
+// SetSFU sets the media forwarder reported by /status.
+func (sh *SignalingHandler) SetSFU(sfu *SFU) {
+	sh.sfu = sfu
+}
+
+// SetAuthenticator enables bearer token authentication.
+// Must be called before RegisterRoutes.
+func (sh *SignalingHandler) SetAuthenticator(auth Authenticator) {
+	sh.auth = auth
+}
+
+// SetOriginPolicy sets the browser origins allowed to call the endpoints.
+func (sh *SignalingHandler) SetOriginPolicy(origins *OriginPolicy) {
+	sh.origins = origins
+}
+
+// SetRateLimiter sets the connection attempt limits for /offer and /connect.
+func (sh *SignalingHandler) SetRateLimiter(limiter *RateLimiter) {
+	sh.limiter = limiter
+}
+
+// RequireRobotCertificates only lets requests with a verified client
+// certificate for the room create Python peers.
+func (sh *SignalingHandler) RequireRobotCertificates() {
+	sh.robotCerts = true
+}
+
 // OfferRequest represents an incoming SDP offer from a client.
 type OfferRequest struct {
 	SDP      string `json:"sdp"`      // SDP offer string
 	Type     string `json:"type"`     // Should be "offer"
 	PeerType string `json:"peerType"` // "web" or "python"
+	Room     string `json:"room"`     // Room (robot) to join; empty for DefaultRoom
+	Trickle  bool   `json:"trickle"`  // Return the answer before ICE gathering completes
+
+	// Set both to resume a suspended peer with an ICE restart offer
+	PeerID       string `json:"peerID"`       // Peer to resume
+	SessionToken string `json:"sessionToken"` // Session token returned with the peer's first answer
 }
 
 // AnswerResponse is sent back after processing an offer.
 type AnswerResponse struct {
+	SDP     string `json:"sdp"`     // SDP answer string
+	Type    string `json:"type"`    // Should be "answer"
+	PeerID  string `json:"peerID"`  // Assigned peer ID
+	Room    string `json:"room"`    // Room the peer joined
+	Trickle bool   `json:"trickle"` // Whether relay candidates must be polled from GET /ice
+
+	SessionToken string `json:"sessionToken"` // Token for resuming the peer after a failure
+}
+
+// ConnectRequest asks the relay to initiate a peer connection.
+type ConnectRequest struct {
+	PeerType string   `json:"peerType"` // "web" or "python"
+	Room     string   `json:"room"`     // Room (robot) to join; empty for DefaultRoom
+	Channels []string `json:"channels"` // Data channel labels (default: ["twist"], plus "cmd" for python)
+	Video    int      `json:"video"`    // Number of video tracks the peer will send
+	Trickle  bool     `json:"trickle"`  // Return the offer before ICE gathering completes
+}
+
+// OfferResponse carries a relay-initiated SDP offer.
+type OfferResponse struct {
+	SDP     string `json:"sdp"`     // SDP offer string
+	Type    string `json:"type"`    // Always "offer"
+	PeerID  string `json:"peerID"`  // Assigned peer ID, used to post the answer
+	Room    string `json:"room"`    // Room the peer joined
+	Trickle bool   `json:"trickle"` // Whether relay candidates must be polled from GET /ice
+
+	SessionToken string `json:"sessionToken"` // Token for resuming the peer after a failure
+}
+
+// AnswerRequest represents an SDP answer to a relay-initiated offer.
+type AnswerRequest struct {
+	PeerID string `json:"peerID"` // Peer ID returned by /connect
 	SDP    string `json:"sdp"`    // SDP answer string
 	Type   string `json:"type"`   // Should be "answer"
-	PeerID string `json:"peerID"` // Assigned peer ID
 }
 
 // ICECandidateRequest represents an ICE candidate submission.
@@ -50,12 +169,55 @@ type ICECandidateRequest struct {
 	SDPMLine  uint16 `json:"sdpMLine"`  // SDP media line index
 }
 
+// ICECandidatesResponse returns the relay's ICE candidates for trickle ICE.
+type ICECandidatesResponse struct {
+	PeerID     string                    `json:"peerID"`     // Peer the candidates belong to
+	Candidates []webrtc.ICECandidateInit `json:"candidates"` // Candidates gathered since the last poll
+	Done       bool                      `json:"done"`       // Whether gathering has completed
+}
+
+// ICEServersResponse lists the ICE servers a client should use.
+// iceServers can be passed to RTCPeerConnection as is.
+type ICEServersResponse struct {
+	ICEServers []ICEServerConfig `json:"iceServers"` // STUN/TURN servers
+	TTL        int64             `json:"ttl"`        // Seconds until TURN credentials expire
+}
+
 // StatusResponse contains server status information.
 type StatusResponse struct {
-	Status     string `json:"status"`     // Server status
-	PeerCount  int    `json:"peerCount"`  // Number of connected peers
-	WebPeers   int    `json:"webPeers"`   // Number of web clients
-	PyPeers    int    `json:"pyPeers"`    // Number of Python clients
+	Status        string     `json:"status"`        // Server status
+	Room          string     `json:"room"`          // Room the counts and state refer to
+	PeerCount     int        `json:"peerCount"`     // Number of connected clients in the room (WebRTC and WebSocket)
+	WebPeers      int        `json:"webPeers"`      // Number of web clients in the room
+	PyPeers       int        `json:"pyPeers"`       // Number of Python clients in the room
+	ControlHolder string     `json:"controlHolder"` // ID of the client holding control ("" if free)
+	EStop         EStopState `json:"estop"`         // Emergency stop latch state
+
+	AudioPublishers []string `json:"audioPublishers"` // Peers whose audio is being forwarded
+}
+
+// ControlRequest represents an admin override of the control lease.
+type ControlRequest struct {
+	Action string `json:"action"` // "grant" or "revoke"
+	PeerID string `json:"peerID"` // Client to hand control to (for "grant")
+}
+
+// ControlResponse reports the current control lease holder.
+type ControlResponse struct {
+	Holder string `json:"holder"` // ID of the client holding control ("" if free)
+	TTLMs  int64  `json:"ttlMs"`  // Lease TTL in milliseconds
+}
+
+// KickRequest names the client to disconnect.
+type KickRequest struct {
+	PeerID string `json:"peerID"` // WebRTC peer or WebSocket client ID
+}
+
+// ConfigResponse is the runtime configuration served by /config.
+// POST /config takes the same shape; omitted sections are left unchanged.
+type ConfigResponse struct {
+	Limits     *LimitsConfig            `json:"limits,omitempty"`     // Velocity envelope (absent if disabled)
+	RoomLimits map[string]*LimitsConfig `json:"roomLimits,omitempty"` // Per-room envelopes; null removes one
 }
 
 // ErrorResponse represents an error response.
@@ -67,20 +229,38 @@ type ErrorResponse struct {
 // RegisterRoutes sets up all HTTP routes on the given ServeMux.
 func (sh *SignalingHandler) RegisterRoutes(mux *http.ServeMux) {
 	// Wrap handlers with CORS middleware
-	mux.HandleFunc("/offer", sh.corsMiddleware(sh.handleOffer))
-	mux.HandleFunc("/answer", sh.corsMiddleware(sh.handleAnswer))
-	mux.HandleFunc("/ice", sh.corsMiddleware(sh.handleICE))
-	mux.HandleFunc("/status", sh.corsMiddleware(sh.handleStatus))
+	mux.HandleFunc("/offer", sh.corsMiddleware(requireAuth(sh.auth, sh.handleOffer)))
+	mux.HandleFunc("/connect", sh.corsMiddleware(requireAuth(sh.auth, sh.handleConnect)))
+	mux.HandleFunc("/answer", sh.corsMiddleware(requireAuth(sh.auth, sh.handleAnswer)))
+	mux.HandleFunc("/ice", sh.corsMiddleware(requireAuth(sh.auth, sh.handleICE)))
+	mux.HandleFunc("/ice-servers", sh.corsMiddleware(requireAuth(sh.auth, sh.handleICEServers)))
+	mux.HandleFunc("/control", sh.corsMiddleware(requireAuth(sh.auth, sh.handleControl)))
+	mux.HandleFunc("/estop", sh.corsMiddleware(requireAuth(sh.auth, sh.handleEStop)))
+	mux.HandleFunc("/estop/reset", sh.corsMiddleware(requireAuth(sh.auth, sh.handleEStopReset)))
+	mux.HandleFunc("/kick", sh.corsMiddleware(requireAuth(sh.auth, sh.handleKick)))
+	mux.HandleFunc("/config", sh.corsMiddleware(requireAuth(sh.auth, sh.handleConfig)))
+	mux.HandleFunc("/robots", sh.corsMiddleware(requireAuth(sh.auth, sh.handleRobots)))
+	mux.HandleFunc("/status", sh.corsMiddleware(requireAuth(sh.auth, sh.handleStatus)))
 	mux.HandleFunc("/health", sh.corsMiddleware(sh.handleHealth))
 }
 
-// corsMiddleware adds CORS headers to allow cross-origin requests.
+// corsMiddleware applies the origin policy and adds CORS headers for
+// allowed origins. Requests from other origins are refused outright, since
+// a "simple" cross-site POST (e.g. /estop) acts without a preflight.
 func (sh *SignalingHandler) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !sh.origins.Check(r, "Signaling") {
+			sh.sendError(w, http.StatusForbidden, "Origin not allowed", r.Header.Get("Origin"))
+			return
+		}
+
 		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if origin := r.Header.Get("Origin"); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -95,14 +275,25 @@ func (sh *SignalingHandler) corsMiddleware(next http.HandlerFunc) http.HandlerFu
 // handleOffer processes an SDP offer and returns an SDP answer.
 // Creates a new peer connection and sets up the data channel.
 //
+// By default the answer is returned once ICE gathering completes, so it
+// carries all of the relay's candidates. With "trickle" set, the answer is
+// returned immediately and the client polls GET /ice for the candidates.
+//
+// A client whose connection failed can resume its peer within the grace
+// period by sending an ICE restart offer with "peerID" and "sessionToken";
+// the peer keeps its ID, type, room and control lease.
+//
 // POST /offer
-// Request:  { "sdp": "...", "type": "offer", "peerType": "web" }
-// Response: { "sdp": "...", "type": "answer", "peerID": "abc123" }
+// Request:  { "sdp": "...", "type": "offer", "peerType": "web", "room": "robot1", "trickle": false }
+// Response: { "sdp": "...", "type": "answer", "peerID": "abc123", "room": "robot1", "trickle": false, "sessionToken": "..." }
 func (sh *SignalingHandler) handleOffer(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST")
 		return
 	}
+	if !sh.allowConnect(w, r) {
+		return
+	}
 
 	var req OfferRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -116,78 +307,270 @@ func (sh *SignalingHandler) handleOffer(w http.ResponseWriter, r *http.Request)
 		peerType = PeerTypeWeb // Default to web
 	}
 
-	// Create new peer
-	peer, err := sh.peerManager.CreatePeer(peerType)
+	room, err := ParseRoom(req.Room)
 	if err != nil {
-		sh.sendError(w, http.StatusInternalServerError, "Failed to create peer", err.Error())
+		sh.sendError(w, http.StatusBadRequest, "Invalid room", err.Error())
 		return
 	}
 
-	// Set remote description (the offer)
-	offer := webrtc.SessionDescription{
-		Type: webrtc.SDPTypeOffer,
-		SDP:  req.SDP,
+	var peer *Peer
+	resumed := req.PeerID != ""
+	if resumed {
+		// Resume a suspended peer, keeping its type and room
+		if owner := sh.peerManager.GetPeer(req.PeerID); owner != nil && sameIdentity(owner.Identity, requestIdentity(r)) {
+			peer = sh.peerManager.ResumePeer(req.PeerID, req.SessionToken)
+		}
+		if peer == nil {
+			sh.sendError(w, http.StatusNotFound, "Session not found", req.PeerID)
+			return
+		}
+		peerType, room = peer.Type, peer.Room
+	} else {
+		if !sh.authorizeType(w, r, peerType, room) {
+			return
+		}
+
+		// Create new peer
+		peer, err = sh.peerManager.CreatePeer(peerType, room, requestIdentity(r), clientIP(r))
+		if err != nil {
+			sh.sendError(w, http.StatusInternalServerError, "Failed to create peer", err.Error())
+			return
+		}
 	}
-	if err := peer.Connection.SetRemoteDescription(offer); err != nil {
-		sh.peerManager.RemovePeer(peer.ID)
-		sh.sendError(w, http.StatusBadRequest, "Invalid SDP offer", err.Error())
+
+	// Must be created before the answer so no candidate is missed
+	gatherComplete := webrtc.GatheringCompletePromise(peer.Connection)
+
+	// Set the offer and create the answer
+	answer, err := sh.peerManager.AcceptOffer(peer, req.SDP)
+	if err != nil {
+		if !resumed {
+			sh.peerManager.RemovePeer(peer.ID)
+		}
+		sh.sendError(w, http.StatusBadRequest, "Failed to answer offer", err.Error())
+		return
+	}
+
+	sdp := answer.SDP
+	if !req.Trickle {
+		// Wait for ICE gathering so the answer carries every candidate
+		<-gatherComplete
+		sdp = peer.Connection.LocalDescription().SDP
+
+		// The candidates are in the SDP; don't hand them out again
+		sh.peerManager.PollCandidates(peer.ID)
+	}
+
+	resp := AnswerResponse{
+		SDP:     sdp,
+		Type:    "answer",
+		PeerID:  peer.ID,
+		Room:    room,
+		Trickle: req.Trickle,
+
+		SessionToken: peer.Token,
+	}
+
+	log.Printf("[Signaling] Offer processed for peer %s (type: %s, room: %s, trickle: %t, resumed: %t)", peer.ID, peerType, room, req.Trickle, resumed)
+	sh.sendJSON(w, http.StatusOK, resp)
+}
+
+// connectChannels validates the data channel labels requested on /connect,
+// dropping duplicates. Only the reliable channel is known to every peer;
+// robots may also ask for the command channel. With none requested, robots get
+// the low-latency command channel alongside the reliable one.
+func connectChannels(peerType PeerType, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		if peerType == PeerTypePython {
+			return []string{ChannelReliable, ChannelCommand}, nil
+		}
+		return []string{ChannelReliable}, nil
+	}
+
+	var channels []string
+	seen := make(map[string]bool)
+	for _, label := range requested {
+		switch {
+		case label == ChannelReliable:
+		case label == ChannelCommand && peerType == PeerTypePython:
+		default:
+			return nil, fmt.Errorf("unsupported channel %q for %s peers", label, peerType)
+		}
+		if !seen[label] {
+			seen[label] = true
+			channels = append(channels, label)
+		}
+	}
+	if !seen[ChannelReliable] {
+		return nil, fmt.Errorf("channels must include %q", ChannelReliable)
+	}
+	return channels, nil
+}
+
+// handleConnect creates a peer with the relay as offerer, for clients that
+// can't easily create offers themselves. The relay opens the requested data
+// channels and returns its SDP offer; the client answers via POST /answer.
+//
+// POST /connect
+// Request:  { "peerType": "python", "room": "robot1", "channels": ["twist", "cmd"], "video": 1, "trickle": false }
+// Response: { "sdp": "...", "type": "offer", "peerID": "abc123", "room": "robot1", "trickle": false }
+func (sh *SignalingHandler) handleConnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST")
 		return
 	}
+	if !sh.allowConnect(w, r) {
+		return
+	}
+
+	var req ConnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sh.sendError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+		return
+	}
+
+	// Validate peer type
+	peerType := PeerType(req.PeerType)
+	if peerType != PeerTypeWeb && peerType != PeerTypePython {
+		peerType = PeerTypeWeb // Default to web
+	}
 
-	// Create answer
-	answer, err := peer.Connection.CreateAnswer(nil)
+	room, err := ParseRoom(req.Room)
 	if err != nil {
-		sh.peerManager.RemovePeer(peer.ID)
-		sh.sendError(w, http.StatusInternalServerError, "Failed to create answer", err.Error())
+		sh.sendError(w, http.StatusBadRequest, "Invalid room", err.Error())
+		return
+	}
+
+	if req.Video < 0 || req.Video > maxConnectVideo {
+		sh.sendError(w, http.StatusBadRequest, "Invalid video count",
+			fmt.Sprintf("video must be between 0 and %d", maxConnectVideo))
+		return
+	}
+
+	channels, err := connectChannels(peerType, req.Channels)
+	if err != nil {
+		sh.sendError(w, http.StatusBadRequest, "Invalid channels", err.Error())
+		return
+	}
+
+	if !sh.authorizeType(w, r, peerType, room) {
+		return
+	}
+
+	// Create new peer
+	peer, err := sh.peerManager.CreatePeer(peerType, room, requestIdentity(r), clientIP(r))
+	if err != nil {
+		sh.sendError(w, http.StatusInternalServerError, "Failed to create peer", err.Error())
 		return
 	}
 
-	// Set local description
-	if err := peer.Connection.SetLocalDescription(answer); err != nil {
+	for _, label := range channels {
+		if err := sh.peerManager.CreateDataChannel(peer, label); err != nil {
+			sh.peerManager.RemovePeer(peer.ID)
+			sh.sendError(w, http.StatusBadRequest, "Failed to create data channel", err.Error())
+			return
+		}
+	}
+
+	// Let robots publish their cameras
+	for i := 0; i < req.Video; i++ {
+		if err := sh.peerManager.AddReceiver(peer, webrtc.RTPCodecTypeVideo); err != nil {
+			sh.peerManager.RemovePeer(peer.ID)
+			sh.sendError(w, http.StatusInternalServerError, "Failed to add video receiver", err.Error())
+			return
+		}
+	}
+
+	// Must be created before the offer so no candidate is missed
+	gatherComplete := webrtc.GatheringCompletePromise(peer.Connection)
+
+	offer, err := sh.peerManager.CreateOffer(peer)
+	if err != nil {
 		sh.peerManager.RemovePeer(peer.ID)
-		sh.sendError(w, http.StatusInternalServerError, "Failed to set local description", err.Error())
+		sh.sendError(w, http.StatusInternalServerError, "Failed to create offer", err.Error())
 		return
 	}
 
-	// Wait for ICE gathering to complete (simplified - in production use trickle ICE)
-	<-webrtc.GatheringCompletePromise(peer.Connection)
+	sdp := offer.SDP
+	if !req.Trickle {
+		// Wait for ICE gathering so the offer carries every candidate
+		<-gatherComplete
+		sdp = peer.Connection.LocalDescription().SDP
 
-	// Send response with complete SDP
-	resp := AnswerResponse{
-		SDP:    peer.Connection.LocalDescription().SDP,
-		Type:   "answer",
-		PeerID: peer.ID,
+		// The candidates are in the SDP; don't hand them out again
+		sh.peerManager.PollCandidates(peer.ID)
+	}
+
+	// Drop the peer if the client never answers
+	time.AfterFunc(answerTimeout, func() {
+		if peer.Connection.RemoteDescription() == nil {
+			log.Printf("[Signaling] No answer from peer %s within %s", peer.ID, answerTimeout)
+			sh.peerManager.RemovePeer(peer.ID)
+		}
+	})
+
+	resp := OfferResponse{
+		SDP:     sdp,
+		Type:    "offer",
+		PeerID:  peer.ID,
+		Room:    room,
+		Trickle: req.Trickle,
+
+		SessionToken: peer.Token,
 	}
 
-	log.Printf("[Signaling] Offer processed for peer %s (type: %s)", peer.ID, peerType)
+	log.Printf("[Signaling] Offer created for peer %s (type: %s, room: %s, channels: %v)", peer.ID, peerType, room, channels)
 	sh.sendJSON(w, http.StatusOK, resp)
 }
 
-// handleAnswer processes an SDP answer (for cases where server creates offer).
-// Currently not used in the main flow but available for future use.
+// handleAnswer applies a client's SDP answer to an offer from /connect.
 //
 // POST /answer
+// Request:  { "peerID": "abc123", "sdp": "...", "type": "answer" }
+// Response: { "status": "ok" }
 func (sh *SignalingHandler) handleAnswer(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST")
 		return
 	}
 
-	// This endpoint is available for advanced scenarios where
-	// the server needs to initiate offers
-	sh.sendJSON(w, http.StatusOK, map[string]string{
-		"message": "Answer endpoint ready",
-	})
+	var req AnswerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sh.sendError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+		return
+	}
+
+	peer := sh.ownedPeer(w, r, req.PeerID)
+	if peer == nil {
+		return
+	}
+
+	if err := sh.peerManager.AcceptAnswer(peer, req.SDP); err != nil {
+		sh.sendError(w, http.StatusBadRequest, "Failed to apply answer", err.Error())
+		return
+	}
+
+	log.Printf("[Signaling] Answer applied for peer %s", req.PeerID)
+	sh.sendJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// handleICE processes an ICE candidate from a client.
-// Adds the candidate to the specified peer connection.
+// handleICE exchanges ICE candidates with a client.
+// POST adds a client candidate to the peer connection; GET returns the
+// relay's candidates gathered since the last poll.
 //
 // POST /ice
 // Request: { "peerID": "...", "candidate": "...", "sdpMid": "...", "sdpMLine": 0 }
+//
+// GET /ice?peerID=abc123
+// Response: { "peerID": "abc123", "candidates": [{ "candidate": "...", "sdpMid": "0", "sdpMLineIndex": 0 }], "done": false }
 func (sh *SignalingHandler) handleICE(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST")
+	switch r.Method {
+	case "GET":
+		sh.handleICEPoll(w, r)
+		return
+	case "POST":
+	default:
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET or POST")
 		return
 	}
 
@@ -197,9 +580,8 @@ func (sh *SignalingHandler) handleICE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	peer := sh.peerManager.GetPeer(req.PeerID)
+	peer := sh.ownedPeer(w, r, req.PeerID)
 	if peer == nil {
-		sh.sendError(w, http.StatusNotFound, "Peer not found", req.PeerID)
 		return
 	}
 
@@ -219,23 +601,360 @@ func (sh *SignalingHandler) handleICE(w http.ResponseWriter, r *http.Request) {
 	sh.sendJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// handleStatus returns the current server status.
+// handleICEPoll returns the relay's pending ICE candidates for a peer.
+// Clients poll until "done" is true.
+func (sh *SignalingHandler) handleICEPoll(w http.ResponseWriter, r *http.Request) {
+	peerID := r.URL.Query().Get("peerID")
+	if sh.ownedPeer(w, r, peerID) == nil {
+		return
+	}
+
+	candidates, done, err := sh.peerManager.PollCandidates(peerID)
+	if err != nil {
+		sh.sendError(w, http.StatusNotFound, "Peer not found", peerID)
+		return
+	}
+
+	sh.sendJSON(w, http.StatusOK, ICECandidatesResponse{
+		PeerID:     peerID,
+		Candidates: candidates,
+		Done:       done,
+	})
+}
+
+// handleICEServers returns the STUN/TURN servers for a client. TURN
+// credentials are generated per request and expire after the TTL.
+//
+// GET /ice-servers
+// Response: { "iceServers": [{ "urls": ["turn:..."], "username": "1700000600:ab12cd34", "credential": "..." }], "ttl": 600 }
+func (sh *SignalingHandler) handleICEServers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET")
+		return
+	}
+	if sh.iceServers == nil {
+		sh.sendJSON(w, http.StatusOK, ICEServersResponse{ICEServers: []ICEServerConfig{}})
+		return
+	}
+
+	user := uuid.New().String()[:8]
+	sh.sendJSON(w, http.StatusOK, ICEServersResponse{
+		ICEServers: sh.iceServers.ForClient(user, time.Now()),
+		TTL:        int64(sh.iceServers.TTL().Seconds()),
+	})
+}
+
+// handleControl reports or overrides the control lease holder.
+//
+// GET  /control?room=robot1
+// Response: { "holder": "abc123", "ttlMs": 10000 }
+//
+// POST /control?room=robot1
+// Request:  { "action": "grant", "peerID": "abc123" } or { "action": "revoke" }
+func (sh *SignalingHandler) handleControl(w http.ResponseWriter, r *http.Request) {
+	if sh.router == nil || sh.router.LeaseTTL() <= 0 {
+		sh.sendError(w, http.StatusServiceUnavailable, "Control lease disabled", "Set LEASE_TTL_MS to enable")
+		return
+	}
+
+	room, ok := sh.queryRoom(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+
+	case "POST":
+		if !sh.authorize(w, r, PermAdmin) {
+			return
+		}
+
+		var req ControlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sh.sendError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+			return
+		}
+
+		var err error
+		switch req.Action {
+		case "grant":
+			if req.PeerID == "" {
+				sh.sendError(w, http.StatusBadRequest, "Missing peerID", "grant requires a peerID")
+				return
+			}
+			err = sh.router.ForceControl(room, req.PeerID)
+		case "revoke":
+			err = sh.router.ForceControl(room, "")
+		default:
+			sh.sendError(w, http.StatusBadRequest, "Invalid action", "Use grant or revoke")
+			return
+		}
+		if err != nil {
+			sh.sendError(w, http.StatusNotFound, "Control handover failed", err.Error())
+			return
+		}
+
+	default:
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET or POST")
+		return
+	}
+
+	sh.sendJSON(w, http.StatusOK, ControlResponse{
+		Holder: sh.router.ControlHolder(room),
+		TTLMs:  sh.router.LeaseTTL().Milliseconds(),
+	})
+}
+
+// handleEStop reports or engages the emergency stop latch.
+//
+// GET  /estop?room=robot1
+// POST /estop?room=robot1
+// Response: { "engaged": true, "source": "http", "since": 1700000000000 }
+func (sh *SignalingHandler) handleEStop(w http.ResponseWriter, r *http.Request) {
+	if sh.router == nil {
+		sh.sendError(w, http.StatusServiceUnavailable, "Emergency stop unavailable", "No router configured")
+		return
+	}
+
+	room, ok := sh.queryRoom(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+
+	case "POST":
+		if err := sh.router.EngageEStop(room, "http:"+r.RemoteAddr); err != nil {
+			if errors.Is(err, ErrRoomNotOpen) {
+				sh.sendError(w, http.StatusNotFound, "Room not open", err.Error())
+				return
+			}
+			sh.sendError(w, http.StatusServiceUnavailable, "Emergency stop unavailable", err.Error())
+			return
+		}
+
+	default:
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET or POST")
+		return
+	}
+
+	sh.sendJSON(w, http.StatusOK, sh.router.EStopState(room))
+}
+
+// handleEStopReset clears the emergency stop latch.
+//
+// POST /estop/reset?room=robot1
+// Response: { "engaged": false }
+func (sh *SignalingHandler) handleEStopReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST")
+		return
+	}
+	if sh.router == nil {
+		sh.sendError(w, http.StatusServiceUnavailable, "Emergency stop unavailable", "No router configured")
+		return
+	}
+
+	room, ok := sh.queryRoom(w, r)
+	if !ok {
+		return
+	}
+
+	// Authenticated requests are checked against the caller's role
+	role := "http"
+	if identity := requestIdentity(r); identity != nil {
+		role = string(identity.Role)
+	}
+	if err := sh.router.ResetEStop(room, "http:"+r.RemoteAddr, role); err != nil {
+		if errors.Is(err, ErrRoomNotOpen) {
+			sh.sendError(w, http.StatusNotFound, "Room not open", err.Error())
+			return
+		}
+		sh.sendError(w, http.StatusForbidden, "Reset not allowed", err.Error())
+		return
+	}
+
+	sh.sendJSON(w, http.StatusOK, sh.router.EStopState(room))
+}
+
+// handleKick disconnects a client. Kicked peers and WebSocket sessions
+// can't be resumed.
+//
+// POST /kick
+// Request:  { "peerID": "abc123" }
+// Response: { "status": "ok" }
+func (sh *SignalingHandler) handleKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use POST")
+		return
+	}
+	if sh.router == nil {
+		sh.sendError(w, http.StatusServiceUnavailable, "Kick unavailable", "No router configured")
+		return
+	}
+	if !sh.authorize(w, r, PermAdmin) {
+		return
+	}
+
+	var req KickRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sh.sendError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+		return
+	}
+
+	if err := sh.router.Kick(req.PeerID); err != nil {
+		sh.sendError(w, http.StatusNotFound, "Peer not found", err.Error())
+		return
+	}
+
+	log.Printf("[Signaling] Kicked %s (by %s)", req.PeerID, sh.requester(r))
+	sh.sendJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleConfig reports or changes the runtime configuration. A "limits"
+// section replaces the whole velocity envelope; omitted limits are zero,
+// which means unlimited. Each "roomLimits" entry replaces the envelope of
+// one room, and a null entry reverts the room to the default envelope.
+//
+// GET  /config
+// POST /config
+// Request:  { "limits": { "maxLinear": {"x": 1, "y": 0, "z": 0}, "lockedAxes": ["linear.z"], "action": "clamp" },
+//
+//	"roomLimits": { "robot1": { "maxLinear": {...}, ... }, "robot2": null } }
+//
+// Response: { "limits": { "maxLinear": {...}, "maxAngular": {...}, "maxLinearSpeed": 0, ... }, "roomLimits": {...} }
+func (sh *SignalingHandler) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if sh.router == nil {
+		sh.sendError(w, http.StatusServiceUnavailable, "Configuration unavailable", "No router configured")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+
+	case "POST":
+		if !sh.authorize(w, r, PermAdmin) {
+			return
+		}
+
+		var req ConfigResponse
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sh.sendError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+			return
+		}
+
+		if req.Limits != nil {
+			limits, err := req.Limits.VelocityLimits()
+			if err != nil {
+				sh.sendError(w, http.StatusBadRequest, "Invalid limits", err.Error())
+				return
+			}
+			sh.router.SetLimits(limits)
+			log.Printf("[Signaling] Velocity limits changed by %s: %s", sh.requester(r), limits.String())
+		}
+
+		// Validate every entry before applying any of them
+		rooms := make(map[string]*VelocityLimits, len(req.RoomLimits))
+		for id, config := range req.RoomLimits {
+			room, err := ParseRoom(id)
+			if err != nil {
+				sh.sendError(w, http.StatusBadRequest, "Invalid room", err.Error())
+				return
+			}
+			if config == nil {
+				rooms[room] = nil
+				continue
+			}
+			limits, err := config.VelocityLimits()
+			if err != nil {
+				sh.sendError(w, http.StatusBadRequest, "Invalid limits", fmt.Sprintf("room %s: %v", room, err))
+				return
+			}
+			rooms[room] = &limits
+		}
+		for room, limits := range rooms {
+			sh.router.SetRoomLimits(room, limits)
+			if limits == nil {
+				log.Printf("[Signaling] Velocity limits for %s reset to default by %s", room, sh.requester(r))
+			} else {
+				log.Printf("[Signaling] Velocity limits for %s changed by %s: %s", room, sh.requester(r), limits.String())
+			}
+		}
+
+	default:
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET or POST")
+		return
+	}
+
+	var resp ConfigResponse
+	if limits, ok := sh.router.Limits(); ok {
+		cfg := limits.Config()
+		resp.Limits = &cfg
+	}
+	if rooms := sh.router.RoomLimits(); len(rooms) > 0 {
+		resp.RoomLimits = make(map[string]*LimitsConfig, len(rooms))
+		for room, limits := range rooms {
+			cfg := limits.Config()
+			resp.RoomLimits[room] = &cfg
+		}
+	}
+	sh.sendJSON(w, http.StatusOK, resp)
+}
+
+// handleRobots lists the online robots.
 //
-// GET /status
+// GET /robots
+// Response: [{ "room": "robot1", "robots": 1, "operators": 2, "controlHolder": "abc123", "estopEngaged": false }]
+func (sh *SignalingHandler) handleRobots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET")
+		return
+	}
+	if sh.router == nil {
+		sh.sendError(w, http.StatusServiceUnavailable, "Robot list unavailable", "No router configured")
+		return
+	}
+
+	sh.sendJSON(w, http.StatusOK, sh.router.Robots())
+}
+
+// handleStatus returns the current server status for one room.
+//
+// GET /status?room=robot1
 func (sh *SignalingHandler) handleStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		sh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", "Use GET")
 		return
 	}
 
-	webPeers := len(sh.peerManager.GetPeersByType(PeerTypeWeb))
-	pyPeers := len(sh.peerManager.GetPeersByType(PeerTypePython))
+	room, ok := sh.queryRoom(w, r)
+	if !ok {
+		return
+	}
+
+	// Count WebSocket clients too, like /robots does
+	var count RoomCount
+	if sh.router != nil {
+		count = sh.router.RoomCount(room)
+	} else {
+		count = sh.peerManager.RoomCounts()[room]
+	}
 
 	resp := StatusResponse{
 		Status:    "running",
-		PeerCount: sh.peerManager.PeerCount(),
-		WebPeers:  webPeers,
-		PyPeers:   pyPeers,
+		Room:      room,
+		PeerCount: count.Web + count.Python,
+		WebPeers:  count.Web,
+		PyPeers:   count.Python,
+	}
+	if sh.router != nil {
+		resp.ControlHolder = sh.router.ControlHolder(room)
+		resp.EStop = sh.router.EStopState(room)
+	}
+	if sh.sfu != nil {
+		resp.AudioPublishers = sh.sfu.AudioPublishers(room)
 	}
 
 	sh.sendJSON(w, http.StatusOK, resp)
@@ -250,6 +969,83 @@ func (sh *SignalingHandler) handleHealth(w http.ResponseWriter, r *http.Request)
 	w.Write([]byte(`{"status":"healthy"}`))
 }
 
+// authorize checks that the request's identity holds a permission.
+// Sends a 403 response and returns false otherwise.
+func (sh *SignalingHandler) authorize(w http.ResponseWriter, r *http.Request, perm Permission) bool {
+	identity := requestIdentity(r)
+	if identity.Can(perm) {
+		return true
+	}
+	log.Printf("[Signaling] Denied %s %s to %s (role: %s)", r.Method, r.URL.Path, identity.Subject, identity.Role)
+	sh.sendError(w, http.StatusForbidden, "Forbidden", "Requires the "+string(perm)+" permission")
+	return false
+}
+
+// authorizeType checks that the request's identity may create a peer of
+// peerType in room: only robots may be Python clients, and with robot
+// certificates required, only over mTLS for the certificate's room.
+func (sh *SignalingHandler) authorizeType(w http.ResponseWriter, r *http.Request, peerType PeerType, room string) bool {
+	if peerType != PeerTypePython {
+		return true
+	}
+	if !sh.robotCerts {
+		return sh.authorize(w, r, PermRobot)
+	}
+
+	if err := checkRobotCertificate(requestIdentity(r), room); err != nil {
+		log.Printf("[Signaling] Denied %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		sh.sendError(w, http.StatusForbidden, "Forbidden", err.Error())
+		return false
+	}
+	return true
+}
+
+// allowConnect applies the connection attempt limit to a request that
+// creates or resumes a peer. Sends a 429 response and returns false if the
+// client's IP is over the limit.
+func (sh *SignalingHandler) allowConnect(w http.ResponseWriter, r *http.Request) bool {
+	ok, retry := sh.limiter.AllowConnect(r, "Signaling")
+	if !ok {
+		setRetryAfter(w, retry)
+		sh.sendError(w, http.StatusTooManyRequests, "Too many connection attempts", "Retry in "+retry.Truncate(time.Millisecond).String())
+	}
+	return ok
+}
+
+// requester describes who made a request, for logs.
+func (sh *SignalingHandler) requester(r *http.Request) string {
+	if identity := requestIdentity(r); identity != nil {
+		return identity.Subject
+	}
+	return r.RemoteAddr
+}
+
+// ownedPeer returns a peer that belongs to the request's identity.
+// Sends an error response and returns nil otherwise.
+func (sh *SignalingHandler) ownedPeer(w http.ResponseWriter, r *http.Request, peerID string) *Peer {
+	peer := sh.peerManager.GetPeer(peerID)
+	if peer == nil {
+		sh.sendError(w, http.StatusNotFound, "Peer not found", peerID)
+		return nil
+	}
+	if !sameIdentity(peer.Identity, requestIdentity(r)) {
+		sh.sendError(w, http.StatusForbidden, "Forbidden", "Peer belongs to another identity")
+		return nil
+	}
+	return peer
+}
+
+// queryRoom reads the room from the "room" query parameter.
+// Sends an error response and returns false if it is invalid.
+func (sh *SignalingHandler) queryRoom(w http.ResponseWriter, r *http.Request) (string, bool) {
+	room, err := ParseRoom(r.URL.Query().Get("room"))
+	if err != nil {
+		sh.sendError(w, http.StatusBadRequest, "Invalid room", err.Error())
+		return "", false
+	}
+	return room, true
+}
+
 // sendJSON sends a JSON response with the given status code.
 func (sh *SignalingHandler) sendJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnectChannels(t *testing.T) {
+	tests := []struct {
+		name      string
+		peerType  PeerType
+		requested []string
+		want      []string
+		ok        bool
+	}{
+		{"web default", PeerTypeWeb, nil, []string{ChannelReliable}, true},
+		{"python default", PeerTypePython, nil, []string{ChannelReliable, ChannelCommand}, true},
+		{"python both", PeerTypePython, []string{"twist", "cmd"}, []string{"twist", "cmd"}, true},
+		{"duplicates", PeerTypePython, []string{"twist", "cmd", "twist", "cmd"}, []string{"twist", "cmd"}, true},
+		{"web reliable only", PeerTypeWeb, []string{"twist"}, []string{"twist"}, true},
+		{"web command", PeerTypeWeb, []string{"twist", "cmd"}, nil, false},
+		{"unknown label", PeerTypePython, []string{"twist", "video"}, nil, false},
+		{"empty label", PeerTypePython, []string{"twist", ""}, nil, false},
+		{"missing reliable", PeerTypePython, []string{"cmd"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := connectChannels(tt.peerType, tt.requested)
+			if (err == nil) != tt.ok {
+				t.Fatalf("connectChannels() error = %v, want ok = %v", err, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("connectChannels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Package main provides stale and out-of-order command rejection.
+//
+// Each Twist carries the sender's timestamp (ms since epoch). The stale
+// filter uses it to:
+//   - Drop (or turn into a stop) commands older than a configured age
+//   - Drop commands whose timestamp goes backwards relative to the last
+//     accepted command from the same source (reordered or replayed frames)
+//
+// Browser clocks are not synchronized with the relay, so the filter can
+// optionally correct for clock skew per source. The skew is estimated from
+// the client timestamps in ping messages: each sample is
+// clientTime - relayTime = skew - oneWayDelay, so the largest recent sample
+// is the one with the least network delay and is used as the estimate.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// skewWindow is the number of recent ping samples used for the skew estimate.
+const skewWindow = 8
+
+// StaleAction selects what happens to a command that is too old.
+type StaleAction string
+
+const (
+	// StaleActionDrop discards stale commands.
+	StaleActionDrop StaleAction = "drop"
+	// StaleActionZero replaces stale commands with a stop.
+	StaleActionZero StaleAction = "zero"
+)
+
+// StaleVerdict is the result of checking a command's timestamp.
+type StaleVerdict int
+
+const (
+	// StaleAccept means the command is fresh and in order.
+	StaleAccept StaleVerdict = iota
+	// StaleZero means the command is too old and must be replaced by a stop.
+	StaleZero
+	// StaleDrop means the command is too old and must be discarded.
+	StaleDrop
+	// StaleReordered means the command is older than one already accepted.
+	StaleReordered
+)
+
+// staleSource holds per-source ordering and clock skew state.
+type staleSource struct {
+	lastTimestamp uint64  // Timestamp of the last forwarded command
+	skewSamples   []int64 // Recent clientTime - relayTime samples (ms)
+}
+
+// StaleFilter rejects commands that are too old or out of order.
+// Thread-safe for concurrent access from multiple goroutines.
+type StaleFilter struct {
+	maxAge          time.Duration // Max command age (0 = no age check)
+	action          StaleAction   // Drop or zero commands past maxAge
+	rejectReordered bool          // Reject timestamps that go backwards
+	skewCorrection  bool          // Correct timestamps by the estimated skew
+
+	mu      sync.Mutex
+	sources map[string]*staleSource
+}
+
+// NewStaleFilter creates a filter with the given age bound and action.
+func NewStaleFilter(maxAge time.Duration, action StaleAction, rejectReordered, skewCorrection bool) *StaleFilter {
+	return &StaleFilter{
+		maxAge:          maxAge,
+		action:          action,
+		rejectReordered: rejectReordered,
+		skewCorrection:  skewCorrection,
+		sources:         make(map[string]*staleSource),
+	}
+}
+
+// source returns the state for id, creating it if needed. Caller holds mu.
+func (f *StaleFilter) source(id string) *staleSource {
+	src, ok := f.sources[id]
+	if !ok {
+		src = &staleSource{}
+		f.sources[id] = src
+	}
+	return src
+}
+
+// ObservePing records a clock skew sample from a client ping timestamp.
+func (f *StaleFilter) ObservePing(sourceID string, clientMs int64, now time.Time) {
+	if clientMs <= 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	src := f.source(sourceID)
+	src.skewSamples = append(src.skewSamples, clientMs-now.UnixMilli())
+	if len(src.skewSamples) > skewWindow {
+		src.skewSamples = src.skewSamples[len(src.skewSamples)-skewWindow:]
+	}
+}
+
+// Check classifies a command by its timestamp. Commands that are accepted, or
+// zeroed and forwarded as a stop, become the latest from their source; dropped
+// and reordered ones don't, so a stale frame can't push the ordering forward.
+// Commands without a timestamp (legacy 48-byte frames) are always accepted.
+func (f *StaleFilter) Check(sourceID string, twist *TwistMessage, now time.Time) StaleVerdict {
+	if twist.Timestamp == 0 {
+		return StaleAccept
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	src := f.source(sourceID)
+	if f.rejectReordered && twist.Timestamp < src.lastTimestamp {
+		return StaleReordered
+	}
+
+	verdict := f.checkAge(src, twist.Timestamp, now)
+	if verdict != StaleDrop && twist.Timestamp > src.lastTimestamp {
+		src.lastTimestamp = twist.Timestamp
+	}
+	return verdict
+}
+
+// checkAge classifies a timestamp against maxAge. Caller holds mu.
+func (f *StaleFilter) checkAge(src *staleSource, timestamp uint64, now time.Time) StaleVerdict {
+	if f.maxAge <= 0 {
+		return StaleAccept
+	}
+
+	sent := int64(timestamp)
+	if f.skewCorrection {
+		sent -= estimateSkew(src.skewSamples)
+	}
+
+	age := time.Duration(now.UnixMilli()-sent) * time.Millisecond
+	if age <= f.maxAge {
+		return StaleAccept
+	}
+	if f.action == StaleActionZero {
+		return StaleZero
+	}
+	return StaleDrop
+}
+
+// Remove forgets all state for a source.
+func (f *StaleFilter) Remove(sourceID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sources, sourceID)
+}
+
+// estimateSkew returns the sample with the least network delay (the max).
+func estimateSkew(samples []int64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	best := samples[0]
+	for _, s := range samples[1:] {
+		if s > best {
+			best = s
+		}
+	}
+	return best
+}
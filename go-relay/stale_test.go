@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaleFilterCheck(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ms := func(d time.Duration) uint64 { return uint64(now.Add(d).UnixMilli()) }
+
+	tests := []struct {
+		name   string
+		action StaleAction
+		skew   time.Duration // Client clock offset seen in a ping (0 = no ping)
+		sent   []uint64      // Timestamps checked in order
+		want   []StaleVerdict
+	}{
+		{"fresh", StaleActionDrop, 0,
+			[]uint64{ms(-100 * time.Millisecond), ms(-50 * time.Millisecond)},
+			[]StaleVerdict{StaleAccept, StaleAccept}},
+		{"stale dropped", StaleActionDrop, 0,
+			[]uint64{ms(-time.Second)},
+			[]StaleVerdict{StaleDrop}},
+		{"stale zeroed", StaleActionZero, 0,
+			[]uint64{ms(-time.Second)},
+			[]StaleVerdict{StaleZero}},
+		{"no timestamp", StaleActionDrop, 0,
+			[]uint64{0, ms(-time.Hour), 0},
+			[]StaleVerdict{StaleAccept, StaleDrop, StaleAccept}},
+		{"reordered", StaleActionDrop, 0,
+			[]uint64{ms(-50 * time.Millisecond), ms(-100 * time.Millisecond), ms(-50 * time.Millisecond)},
+			[]StaleVerdict{StaleAccept, StaleReordered, StaleAccept}},
+		{"dropped then fresh", StaleActionDrop, 0,
+			[]uint64{ms(-time.Second), ms(-2 * time.Second), ms(-100 * time.Millisecond)},
+			[]StaleVerdict{StaleDrop, StaleDrop, StaleAccept}},
+		// A zeroed frame is forwarded as a stop, so older ones are reordered
+		{"zeroed frame advances", StaleActionZero, 0,
+			[]uint64{ms(-time.Second), ms(-2 * time.Second)},
+			[]StaleVerdict{StaleZero, StaleReordered}},
+		{"skew corrected", StaleActionDrop, -time.Hour,
+			[]uint64{ms(-time.Hour - 100*time.Millisecond)},
+			[]StaleVerdict{StaleAccept}},
+		{"skew corrected, still stale", StaleActionDrop, -time.Hour,
+			[]uint64{ms(-time.Hour - time.Second)},
+			[]StaleVerdict{StaleDrop}},
+		{"client ahead", StaleActionDrop, time.Hour,
+			[]uint64{ms(time.Hour - 100*time.Millisecond)},
+			[]StaleVerdict{StaleAccept}},
+		{"client ahead, uncorrected", StaleActionDrop, time.Hour,
+			[]uint64{ms(-100 * time.Millisecond)},
+			[]StaleVerdict{StaleDrop}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewStaleFilter(500*time.Millisecond, tt.action, true, tt.skew != 0)
+			if tt.skew != 0 {
+				f.ObservePing("client", now.Add(tt.skew).UnixMilli(), now)
+			}
+
+			for i, ts := range tt.sent {
+				if got := f.Check("client", &TwistMessage{Timestamp: ts}, now); got != tt.want[i] {
+					t.Errorf("Check(#%d) = %d, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStaleFilterDropDoesNotAdvance(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	f := NewStaleFilter(500*time.Millisecond, StaleActionDrop, true, true)
+
+	// One fast ping puts the skew estimate at +1h
+	f.ObservePing("client", now.Add(time.Hour).UnixMilli(), now)
+	dropped := uint64(now.Add(time.Hour - time.Second).UnixMilli())
+	if got := f.Check("client", &TwistMessage{Timestamp: dropped}, now); got != StaleDrop {
+		t.Fatalf("stale command: verdict %d, want drop", got)
+	}
+
+	// Slower pings push it out of the window and lower the estimate by 1s
+	for i := 0; i < skewWindow; i++ {
+		f.ObservePing("client", now.Add(time.Hour-time.Second).UnixMilli(), now)
+	}
+
+	// Older than the dropped command, but fresh and newer than anything
+	// forwarded, so it must not be rejected as reordered
+	if got := f.Check("client", &TwistMessage{Timestamp: dropped - 200}, now); got != StaleAccept {
+		t.Errorf("command older than the dropped one: verdict %d, want accept", got)
+	}
+}
+
+func TestStaleFilterReorderingDisabled(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	f := NewStaleFilter(0, StaleActionDrop, false, false)
+
+	for _, ts := range []uint64{2000, 1000, 3000} {
+		if got := f.Check("client", &TwistMessage{Timestamp: ts}, now); got != StaleAccept {
+			t.Errorf("Check(%d) = %d, want accept", ts, got)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package main provides the latest-value cache for robot telemetry.
+//
+// Telemetry messages (odometry, battery state, diagnostics) flow from Python
+// clients to every web client in the same room. The relay keeps the most
+// recent frame of each kind per room so that an operator who joins later sees
+// the current robot state immediately instead of waiting for the next
+// update. Diagnostics are cached per status name, since a robot typically
+// reports several components; at most maxDiagnosticsPerRoom names are kept
+// per room, evicting the oldest.
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxDiagnosticsPerRoom bounds the diagnostic status names cached per room,
+// since the names are chosen by the sender.
+const maxDiagnosticsPerRoom = 64
+
+// telemetryEntry is one cached telemetry frame.
+type telemetryEntry struct {
+	sourceID string    // Python client that sent it
+	room     string    // Room it was sent in
+	frame    []byte    // Framed envelope as forwarded to web clients
+	received time.Time // When it arrived
+	named    bool      // Diagnostics entry, keyed by status name
+}
+
+// TelemetryCache holds the latest telemetry frame per room and kind.
+// Thread-safe for concurrent access from multiple goroutines.
+type TelemetryCache struct {
+	mu      sync.RWMutex
+	entries map[string]*telemetryEntry // Indexed by telemetryKey
+}
+
+// NewTelemetryCache creates an empty cache.
+func NewTelemetryCache() *TelemetryCache {
+	return &TelemetryCache{
+		entries: make(map[string]*telemetryEntry),
+	}
+}
+
+// telemetryKey returns the cache key for a message.
+func telemetryKey(room string, msgType MessageType, msg Message) string {
+	if diag, ok := msg.(*DiagnosticStatusMessage); ok {
+		return room + "/" + msgType.String() + "/" + diag.Name
+	}
+	return room + "/" + msgType.String()
+}
+
+// Store records frame as the latest value for msg's kind in a room.
+func (c *TelemetryCache) Store(sourceID, room string, msgType MessageType, msg Message, frame []byte) {
+	_, named := msg.(*DiagnosticStatusMessage)
+	entry := &telemetryEntry{
+		sourceID: sourceID,
+		room:     room,
+		frame:    append([]byte(nil), frame...),
+		received: time.Now(),
+		named:    named,
+	}
+	key := telemetryKey(room, msgType, msg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && named {
+		c.evictDiagnostics(room)
+	}
+	c.entries[key] = entry
+}
+
+// evictDiagnostics drops the oldest diagnostics entries of a room until
+// there is room for one more. Called with c.mu held.
+func (c *TelemetryCache) evictDiagnostics(room string) {
+	for {
+		count := 0
+		var oldestKey string
+		var oldest *telemetryEntry
+		for key, entry := range c.entries {
+			if entry.room != room || !entry.named {
+				continue
+			}
+			count++
+			if oldest == nil || entry.received.Before(oldest.received) {
+				oldestKey, oldest = key, entry
+			}
+		}
+		if count < maxDiagnosticsPerRoom {
+			return
+		}
+		delete(c.entries, oldestKey)
+	}
+}
+
+// Snapshot returns all cached frames for a room, ordered by key.
+func (c *TelemetryCache) Snapshot(room string) [][]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]string, 0, len(c.entries))
+	for key, entry := range c.entries {
+		if entry.room == room {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	frames := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		frames = append(frames, c.entries[key].frame)
+	}
+	return frames
+}
+
+// RemoveSource drops every frame sent by a client, so a disconnected robot
+// isn't reported with stale state.
+func (c *TelemetryCache) RemoveSource(sourceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.sourceID == sourceID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Len returns the number of cached frames.
+func (c *TelemetryCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
@@ -0,0 +1,277 @@
+// Package main provides HTTPS/WSS serving with certificate hot reload.
+//
+// Browsers only expose getUserMedia (push-to-talk) and, on some platforms,
+// the Gamepad API to secure contexts, so a relay that operators reach by
+// anything but localhost has to serve TLS.
+//
+// The certificate and key are read from PEM files and polled for changes;
+// a renewed pair (e.g. from certbot) is picked up without a restart, and a
+// pair that fails to load keeps the previous certificate in service. For
+// development a self-signed certificate for localhost and this host's names
+// can be generated on first run and is reused afterwards.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Self-signed development certificate settings
+const (
+	devCertFile     = "relay-dev-cert.pem"
+	devKeyFile      = "relay-dev-key.pem"
+	devCertValidity = 365 * 24 * time.Hour
+)
+
+// fileStamp identifies a version of a file for change detection.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// CertReloader serves a certificate loaded from disk and reloads it when
+// the files change. Thread-safe for concurrent TLS handshakes.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	cert   *tls.Certificate // Certificate in service
+	stamps [2]fileStamp     // Cert and key file versions it was loaded from
+	mu     sync.RWMutex     // Protects cert and stamps
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewCertReloader loads the certificate and key from PEM files.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		stop:     make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the certificate in service; it is meant for
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Start polls the files every interval and reloads them when they change.
+func (r *CertReloader) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.check()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+
+	log.Printf("[TLS] Watching %s and %s (every %s)", r.certFile, r.keyFile, interval)
+}
+
+// Stop terminates the polling loop.
+func (r *CertReloader) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+// check reloads the certificate if either file changed since it was loaded.
+func (r *CertReloader) check() {
+	stamps, err := r.stat()
+	if err != nil {
+		return // Mid-rotation; try again on the next tick
+	}
+
+	r.mu.RLock()
+	changed := stamps != r.stamps
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := r.reload(); err != nil {
+		log.Printf("[TLS] Reload failed, keeping the current certificate: %v", err)
+		// Don't retry until the files change again
+		r.mu.Lock()
+		r.stamps = stamps
+		r.mu.Unlock()
+	}
+}
+
+// reload loads the certificate and key and puts them in service.
+func (r *CertReloader) reload() error {
+	stamps, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.stamps = stamps
+	r.mu.Unlock()
+
+	log.Printf("[TLS] Loaded certificate for %v (expires %s)",
+		cert.Leaf.DNSNames, cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// stat returns the current versions of the cert and key files.
+func (r *CertReloader) stat() ([2]fileStamp, error) {
+	var stamps [2]fileStamp
+	for i, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return stamps, err
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// loadCertificates sets up the certificate source configured by
+// TLS_CERT_FILE/TLS_KEY_FILE or TLS_SELF_SIGNED. Returns nil if TLS is disabled.
+func loadCertificates(config *Config) (*CertReloader, error) {
+	certFile, keyFile := config.TLSCertFile, config.TLSKeyFile
+	if config.TLSSelfSigned {
+		if certFile == "" {
+			certFile = devCertFile
+		}
+		if keyFile == "" {
+			keyFile = devKeyFile
+		}
+		if err := ensureDevCertificate(certFile, keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	return NewCertReloader(certFile, keyFile)
+}
+
+// ensureDevCertificate writes a self-signed certificate and key for
+// localhost and this host's names, unless both files already exist.
+func ensureDevCertificate(certFile, keyFile string) error {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+	for _, err := range []error{certErr, keyErr} {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"webrtc-relay development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Key first, so a crash can't leave a certificate without its key
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+
+	log.Printf("[TLS] Generated self-signed certificate %s for %v %v", certFile, template.DNSNames, template.IPAddresses)
+	return nil
+}
+
+// redirectHandler sends every request to the same path over HTTPS on httpsPort.
+func redirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Missing Host header", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, fmt.Sprintf("https://%s%s", host, r.URL.RequestURI()), http.StatusMovedPermanently)
+	})
+}
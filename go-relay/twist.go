@@ -8,12 +8,34 @@
 //   - Bytes 32-39: angular.y (float64)
 //   - Bytes 40-47: angular.z (float64)
 //   - Bytes 48-55: timestamp (uint64, milliseconds since epoch)
+//
+// Framed Envelope Format (little-endian):
+//   - Byte 0:      magic (0xA5)
+//   - Byte 1:      version (1)
+//   - Byte 2:      message type id
+//   - Byte 3:      flags (reserved, 0)
+//   - Bytes 4-7:   sequence number (uint32, per sender)
+//   - Bytes 8-N:   payload (e.g. a 56-byte Twist)
+//   - Last 4:      CRC32 (IEEE) over all preceding bytes
+//
+// Bare 48/56-byte Twist frames are still accepted from legacy clients.
+//
+// Message Registry:
+//
+// Each payload type carried in an envelope is registered with a type id, its
+// ROS type name and a constructor. Registered messages implement binary
+// (MarshalBinary/UnmarshalBinary) and JSON (struct tags) forms. Variable
+// length fields are encoded as a uint16 count followed by the elements, and
+// every stamped message starts with a std_msgs/Header:
+//   - stamp (uint64, milliseconds since epoch)
+//   - frame_id (uint16 length + UTF-8 bytes)
 package main
 
 import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"math"
 	"time"
 )
@@ -27,6 +49,59 @@ const TwistMessageSizeLegacy = 48
 // ErrInvalidMessageSize indicates the message size doesn't match expected format.
 var ErrInvalidMessageSize = errors.New("invalid twist message size")
 
+// Envelope framing constants.
+const (
+	EnvelopeMagic      = 0xA5 // First byte of every framed message
+	EnvelopeVersion    = 1    // Current envelope version
+	EnvelopeHeaderSize = 8    // magic + version + type + flags + seq
+	EnvelopeCRCSize    = 4    // Trailing CRC32
+)
+
+// MessageType identifies the payload carried by an envelope.
+type MessageType uint8
+
+const (
+	// MsgTypeTwist carries a geometry_msgs/Twist in the 56-byte binary format.
+	MsgTypeTwist MessageType = 1
+	// MsgTypeTwistStamped carries a geometry_msgs/TwistStamped.
+	MsgTypeTwistStamped MessageType = 2
+	// MsgTypeJoy carries a sensor_msgs/Joy.
+	MsgTypeJoy MessageType = 3
+	// MsgTypeBool carries a std_msgs/Bool (e.g. gripper open/close).
+	MsgTypeBool MessageType = 4
+	// MsgTypePoseStamped carries a geometry_msgs/PoseStamped (e.g. navigation goal).
+	MsgTypePoseStamped MessageType = 5
+	// MsgTypeOdometry carries a nav_msgs/Odometry (robot telemetry).
+	MsgTypeOdometry MessageType = 6
+	// MsgTypeBatteryState carries a sensor_msgs/BatteryState (robot telemetry).
+	MsgTypeBatteryState MessageType = 7
+	// MsgTypeDiagnosticStatus carries a diagnostic_msgs/DiagnosticStatus (robot telemetry).
+	MsgTypeDiagnosticStatus MessageType = 8
+)
+
+// ErrUnknownMessageType indicates a type id or name that isn't registered.
+var ErrUnknownMessageType = errors.New("unknown message type")
+
+// ErrTruncatedMessage indicates a payload shorter than its encoded fields.
+var ErrTruncatedMessage = errors.New("truncated message")
+
+// Envelope decoding errors.
+var (
+	ErrEnvelopeTooShort   = errors.New("envelope too short")
+	ErrInvalidMagic       = errors.New("invalid envelope magic")
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+	ErrChecksumMismatch   = errors.New("envelope checksum mismatch")
+)
+
+// Envelope is a decoded framed message.
+type Envelope struct {
+	Version uint8       // Envelope format version
+	Type    MessageType // Payload type id
+	Flags   uint8       // Reserved flags
+	Seq     uint32      // Per-sender sequence number
+	Payload []byte      // Message payload
+}
+
 // Vector3 represents a 3D vector with X, Y, Z components.
 // Used for both linear and angular velocity in Twist messages.
 type Vector3 struct {
@@ -125,6 +200,79 @@ func DecodeTwist(data []byte) (*TwistMessage, error) {
 	return twist, nil
 }
 
+// EncodeEnvelope wraps a payload in a versioned frame with a trailing CRC32.
+//
+// Example:
+//
+//	frame := EncodeEnvelope(MsgTypeTwist, 0, seq, EncodeTwist(twist))
+func EncodeEnvelope(msgType MessageType, flags uint8, seq uint32, payload []byte) []byte {
+	buf := make([]byte, EnvelopeHeaderSize+len(payload)+EnvelopeCRCSize)
+
+	buf[0] = EnvelopeMagic
+	buf[1] = EnvelopeVersion
+	buf[2] = byte(msgType)
+	buf[3] = flags
+	binary.LittleEndian.PutUint32(buf[4:8], seq)
+	copy(buf[EnvelopeHeaderSize:], payload)
+
+	crcOffset := len(buf) - EnvelopeCRCSize
+	binary.LittleEndian.PutUint32(buf[crcOffset:], crc32.ChecksumIEEE(buf[:crcOffset]))
+
+	return buf
+}
+
+// DecodeEnvelope parses and verifies a framed message.
+// The returned payload aliases data.
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	if len(data) < EnvelopeHeaderSize+EnvelopeCRCSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrEnvelopeTooShort, len(data))
+	}
+	if data[0] != EnvelopeMagic {
+		return nil, fmt.Errorf("%w: 0x%02x", ErrInvalidMagic, data[0])
+	}
+	if data[1] != EnvelopeVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, data[1])
+	}
+
+	crcOffset := len(data) - EnvelopeCRCSize
+	want := binary.LittleEndian.Uint32(data[crcOffset:])
+	if got := crc32.ChecksumIEEE(data[:crcOffset]); got != want {
+		return nil, fmt.Errorf("%w: got 0x%08x, want 0x%08x", ErrChecksumMismatch, got, want)
+	}
+
+	return &Envelope{
+		Version: data[1],
+		Type:    MessageType(data[2]),
+		Flags:   data[3],
+		Seq:     binary.LittleEndian.Uint32(data[4:8]),
+		Payload: data[EnvelopeHeaderSize:crcOffset],
+	}, nil
+}
+
+// IsEnvelope reports whether data looks like a framed message.
+// A bare Twist frame can start with the magic byte, so callers should fall
+// back to the legacy format if DecodeEnvelope fails on a 48/56-byte frame.
+func IsEnvelope(data []byte) bool {
+	return len(data) >= EnvelopeHeaderSize+EnvelopeCRCSize && data[0] == EnvelopeMagic
+}
+
+// IsMotionFrame reports whether data is a binary motion command: a bare
+// legacy Twist or a framed message whose type is registered as Motion.
+// Text (JSON) messages are never motion frames.
+func IsMotionFrame(data []byte) bool {
+	if len(data) == 0 || data[0] == '{' {
+		return false
+	}
+	if len(data) == TwistMessageSize || len(data) == TwistMessageSizeLegacy {
+		return true
+	}
+	if !IsEnvelope(data) {
+		return false
+	}
+	info, ok := LookupMessage(MessageType(data[2]))
+	return ok && info.Motion
+}
+
 // GetLatencyMs calculates the latency from the message timestamp to now.
 // Returns latency in milliseconds.
 func (t *TwistMessage) GetLatencyMs() int64 {
@@ -163,3 +311,506 @@ func (t *TwistMessage) Clone() *TwistMessage {
 		Timestamp: t.Timestamp,
 	}
 }
+
+// MarshalBinary encodes the Twist in the 56-byte binary format.
+func (t *TwistMessage) MarshalBinary() ([]byte, error) {
+	return EncodeTwist(t), nil
+}
+
+// UnmarshalBinary decodes a 48 or 56-byte binary Twist.
+func (t *TwistMessage) UnmarshalBinary(data []byte) error {
+	decoded, err := DecodeTwist(data)
+	if err != nil {
+		return err
+	}
+	*t = *decoded
+	return nil
+}
+
+// Message is a registered payload type with binary and JSON forms.
+type Message interface {
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+}
+
+// MessageInfo describes a registered message type.
+type MessageInfo struct {
+	Type      MessageType    // Envelope type id
+	Name      string         // ROS type name, e.g. "geometry_msgs/Twist"
+	New       func() Message // Creates an empty message for decoding
+	Telemetry bool           // Robot-to-operator only; latest value is cached
+	Motion    bool           // Drives the robot; never replayed to a resumed robot
+	MaxSize   int            // Largest accepted payload in bytes (0 = no cap)
+}
+
+var (
+	messagesByType = make(map[MessageType]*MessageInfo)
+	messagesByName = make(map[string]*MessageInfo)
+)
+
+func init() {
+	RegisterMessage(&MessageInfo{Type: MsgTypeTwist, Name: "geometry_msgs/Twist",
+		New: func() Message { return &TwistMessage{} }, Motion: true, MaxSize: TwistMessageSize})
+	RegisterMessage(&MessageInfo{Type: MsgTypeTwistStamped, Name: "geometry_msgs/TwistStamped",
+		New: func() Message { return &TwistStampedMessage{} }, Motion: true, MaxSize: 512})
+	RegisterMessage(&MessageInfo{Type: MsgTypeJoy, Name: "sensor_msgs/Joy",
+		New: func() Message { return &JoyMessage{} }, Motion: true, MaxSize: 1024})
+	RegisterMessage(&MessageInfo{Type: MsgTypeBool, Name: "std_msgs/Bool",
+		New: func() Message { return &BoolMessage{} }, MaxSize: 1})
+	RegisterMessage(&MessageInfo{Type: MsgTypePoseStamped, Name: "geometry_msgs/PoseStamped",
+		New: func() Message { return &PoseStampedMessage{} }, MaxSize: 512})
+	RegisterMessage(&MessageInfo{Type: MsgTypeOdometry, Name: "nav_msgs/Odometry",
+		New: func() Message { return &OdometryMessage{} }, Telemetry: true, MaxSize: 1024})
+	RegisterMessage(&MessageInfo{Type: MsgTypeBatteryState, Name: "sensor_msgs/BatteryState",
+		New: func() Message { return &BatteryStateMessage{} }, Telemetry: true, MaxSize: 512})
+	RegisterMessage(&MessageInfo{Type: MsgTypeDiagnosticStatus, Name: "diagnostic_msgs/DiagnosticStatus",
+		New: func() Message { return &DiagnosticStatusMessage{} }, Telemetry: true, MaxSize: 16 * 1024})
+}
+
+// RegisterMessage adds a message type to the registry.
+// Panics if the type id or name is already registered.
+func RegisterMessage(info *MessageInfo) {
+	if _, exists := messagesByType[info.Type]; exists {
+		panic(fmt.Sprintf("message type %d already registered", info.Type))
+	}
+	if _, exists := messagesByName[info.Name]; exists {
+		panic(fmt.Sprintf("message type %s already registered", info.Name))
+	}
+	messagesByType[info.Type] = info
+	messagesByName[info.Name] = info
+}
+
+// LookupMessage returns the registered message info for a type id.
+func LookupMessage(msgType MessageType) (*MessageInfo, bool) {
+	info, ok := messagesByType[msgType]
+	return info, ok
+}
+
+// LookupMessageName returns the registered message info for a ROS type name.
+func LookupMessageName(name string) (*MessageInfo, bool) {
+	info, ok := messagesByName[name]
+	return info, ok
+}
+
+// DecodeMessage decodes a binary payload of a registered type.
+func DecodeMessage(msgType MessageType, payload []byte) (Message, error) {
+	info, ok := LookupMessage(msgType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMessageType, msgType)
+	}
+
+	msg := info.New()
+	if err := msg.UnmarshalBinary(payload); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", info.Name, err)
+	}
+	return msg, nil
+}
+
+// String returns the ROS type name, or the numeric id if unregistered.
+func (m MessageType) String() string {
+	if info, ok := LookupMessage(m); ok {
+		return info.Name
+	}
+	return fmt.Sprintf("type(%d)", uint8(m))
+}
+
+// Header represents a std_msgs/Header.
+type Header struct {
+	Stamp   uint64 `json:"stamp"`    // Timestamp in milliseconds since epoch
+	FrameID string `json:"frame_id"` // Coordinate frame
+}
+
+// TwistStampedMessage represents a geometry_msgs/TwistStamped.
+//
+// Binary Format: header, then linear and angular vectors (6 x float64).
+// The Twist timestamp mirrors header.stamp.
+type TwistStampedMessage struct {
+	Header Header       `json:"header"`
+	Twist  TwistMessage `json:"twist"`
+}
+
+// MarshalBinary encodes the TwistStamped message.
+func (m *TwistStampedMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.header(m.Header)
+	w.vector3(m.Twist.Linear)
+	w.vector3(m.Twist.Angular)
+	return w.buf, w.err
+}
+
+// UnmarshalBinary decodes a TwistStamped message.
+func (m *TwistStampedMessage) UnmarshalBinary(data []byte) error {
+	r := &wireReader{buf: data}
+	m.Header = r.header()
+	m.Twist.Linear = r.vector3()
+	m.Twist.Angular = r.vector3()
+	m.Twist.Timestamp = m.Header.Stamp
+	return r.done()
+}
+
+// JoyMessage represents a sensor_msgs/Joy.
+//
+// Binary Format: header, uint16 axis count, axes (float32 each),
+// uint16 button count, buttons (int32 each).
+type JoyMessage struct {
+	Header  Header    `json:"header"`
+	Axes    []float32 `json:"axes"`    // Axis values, typically in [-1, 1]
+	Buttons []int32   `json:"buttons"` // Button states (0 or 1)
+}
+
+// MarshalBinary encodes the Joy message.
+func (m *JoyMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.header(m.Header)
+	w.count(len(m.Axes))
+	for _, axis := range m.Axes {
+		w.uint32(math.Float32bits(axis))
+	}
+	w.count(len(m.Buttons))
+	for _, button := range m.Buttons {
+		w.uint32(uint32(button))
+	}
+	return w.buf, w.err
+}
+
+// UnmarshalBinary decodes a Joy message.
+func (m *JoyMessage) UnmarshalBinary(data []byte) error {
+	r := &wireReader{buf: data}
+	m.Header = r.header()
+	m.Axes = make([]float32, r.count(4))
+	for i := range m.Axes {
+		m.Axes[i] = math.Float32frombits(r.uint32())
+	}
+	m.Buttons = make([]int32, r.count(4))
+	for i := range m.Buttons {
+		m.Buttons[i] = int32(r.uint32())
+	}
+	return r.done()
+}
+
+// BoolMessage represents a std_msgs/Bool.
+//
+// Binary Format: a single byte, 0 or 1.
+type BoolMessage struct {
+	Data bool `json:"data"`
+}
+
+// MarshalBinary encodes the Bool message.
+func (m *BoolMessage) MarshalBinary() ([]byte, error) {
+	if m.Data {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
+
+// UnmarshalBinary decodes a Bool message.
+func (m *BoolMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return fmt.Errorf("%w: expected 1 byte, got %d bytes", ErrInvalidMessageSize, len(data))
+	}
+	m.Data = data[0] != 0
+	return nil
+}
+
+// Quaternion represents a geometry_msgs/Quaternion orientation.
+type Quaternion struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+	W float64 `json:"w"`
+}
+
+// Pose represents a geometry_msgs/Pose.
+type Pose struct {
+	Position    Vector3    `json:"position"`
+	Orientation Quaternion `json:"orientation"`
+}
+
+// PoseStampedMessage represents a geometry_msgs/PoseStamped.
+//
+// Binary Format: header, position (3 x float64), orientation (4 x float64).
+type PoseStampedMessage struct {
+	Header Header `json:"header"`
+	Pose   Pose   `json:"pose"`
+}
+
+// MarshalBinary encodes the PoseStamped message.
+func (m *PoseStampedMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.header(m.Header)
+	w.pose(m.Pose)
+	return w.buf, w.err
+}
+
+// UnmarshalBinary decodes a PoseStamped message.
+func (m *PoseStampedMessage) UnmarshalBinary(data []byte) error {
+	r := &wireReader{buf: data}
+	m.Header = r.header()
+	m.Pose = r.pose()
+	return r.done()
+}
+
+// OdometryMessage represents a nav_msgs/Odometry (covariances omitted).
+//
+// Binary Format: header, child_frame_id (uint16 length + bytes), pose
+// (position + orientation, 7 x float64), twist (linear + angular, 6 x float64).
+type OdometryMessage struct {
+	Header       Header       `json:"header"`
+	ChildFrameID string       `json:"child_frame_id"`
+	Pose         Pose         `json:"pose"`
+	Twist        TwistMessage `json:"twist"`
+}
+
+// MarshalBinary encodes the Odometry message.
+func (m *OdometryMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.header(m.Header)
+	w.string(m.ChildFrameID)
+	w.pose(m.Pose)
+	w.vector3(m.Twist.Linear)
+	w.vector3(m.Twist.Angular)
+	return w.buf, w.err
+}
+
+// UnmarshalBinary decodes an Odometry message.
+func (m *OdometryMessage) UnmarshalBinary(data []byte) error {
+	r := &wireReader{buf: data}
+	m.Header = r.header()
+	m.ChildFrameID = r.string()
+	m.Pose = r.pose()
+	m.Twist.Linear = r.vector3()
+	m.Twist.Angular = r.vector3()
+	m.Twist.Timestamp = m.Header.Stamp
+	return r.done()
+}
+
+// BatteryStateMessage represents a sensor_msgs/BatteryState (per-cell data omitted).
+//
+// Binary Format: header, voltage, current, charge, capacity, percentage
+// (float32 each), power_supply_status (uint8).
+type BatteryStateMessage struct {
+	Header            Header  `json:"header"`
+	Voltage           float32 `json:"voltage"`             // V
+	Current           float32 `json:"current"`             // A (negative when discharging)
+	Charge            float32 `json:"charge"`              // Ah
+	Capacity          float32 `json:"capacity"`            // Ah
+	Percentage        float32 `json:"percentage"`          // 0 to 1
+	PowerSupplyStatus uint8   `json:"power_supply_status"` // POWER_SUPPLY_STATUS_* constant
+}
+
+// MarshalBinary encodes the BatteryState message.
+func (m *BatteryStateMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.header(m.Header)
+	for _, v := range []float32{m.Voltage, m.Current, m.Charge, m.Capacity, m.Percentage} {
+		w.uint32(math.Float32bits(v))
+	}
+	w.buf = append(w.buf, m.PowerSupplyStatus)
+	return w.buf, w.err
+}
+
+// UnmarshalBinary decodes a BatteryState message.
+func (m *BatteryStateMessage) UnmarshalBinary(data []byte) error {
+	r := &wireReader{buf: data}
+	m.Header = r.header()
+	for _, v := range []*float32{&m.Voltage, &m.Current, &m.Charge, &m.Capacity, &m.Percentage} {
+		*v = math.Float32frombits(r.uint32())
+	}
+	if b := r.next(1); b != nil {
+		m.PowerSupplyStatus = b[0]
+	}
+	return r.done()
+}
+
+// KeyValue represents a diagnostic_msgs/KeyValue.
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// DiagnosticStatusMessage represents a diagnostic_msgs/DiagnosticStatus with
+// free-form key/value pairs.
+//
+// Binary Format: level (uint8), name, message, hardware_id (uint16 length +
+// bytes each), uint16 value count, then key and value strings per pair.
+type DiagnosticStatusMessage struct {
+	Level      uint8      `json:"level"` // 0=OK, 1=WARN, 2=ERROR, 3=STALE
+	Name       string     `json:"name"`
+	Message    string     `json:"message"`
+	HardwareID string     `json:"hardware_id"`
+	Values     []KeyValue `json:"values"`
+}
+
+// MarshalBinary encodes the DiagnosticStatus message.
+func (m *DiagnosticStatusMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.buf = append(w.buf, m.Level)
+	w.string(m.Name)
+	w.string(m.Message)
+	w.string(m.HardwareID)
+	w.count(len(m.Values))
+	for _, kv := range m.Values {
+		w.string(kv.Key)
+		w.string(kv.Value)
+	}
+	return w.buf, w.err
+}
+
+// UnmarshalBinary decodes a DiagnosticStatus message.
+func (m *DiagnosticStatusMessage) UnmarshalBinary(data []byte) error {
+	r := &wireReader{buf: data}
+	if b := r.next(1); b != nil {
+		m.Level = b[0]
+	}
+	m.Name = r.string()
+	m.Message = r.string()
+	m.HardwareID = r.string()
+	// Each pair is at least two uint16 lengths
+	m.Values = make([]KeyValue, r.count(4))
+	for i := range m.Values {
+		m.Values[i] = KeyValue{Key: r.string(), Value: r.string()}
+	}
+	return r.done()
+}
+
+// wireWriter appends little-endian fields to a buffer.
+// The first error (e.g. an oversized field) is kept in err.
+type wireWriter struct {
+	buf []byte
+	err error
+}
+
+func (w *wireWriter) uint16(v uint16) { w.buf = binary.LittleEndian.AppendUint16(w.buf, v) }
+func (w *wireWriter) uint32(v uint32) { w.buf = binary.LittleEndian.AppendUint32(w.buf, v) }
+func (w *wireWriter) uint64(v uint64) { w.buf = binary.LittleEndian.AppendUint64(w.buf, v) }
+func (w *wireWriter) float64(v float64) {
+	w.uint64(math.Float64bits(v))
+}
+
+func (w *wireWriter) count(n int) {
+	if n > math.MaxUint16 {
+		w.err = fmt.Errorf("field too long: %d elements", n)
+		n = 0
+	}
+	w.uint16(uint16(n))
+}
+
+func (w *wireWriter) string(s string) {
+	w.count(len(s))
+	if len(s) <= math.MaxUint16 {
+		w.buf = append(w.buf, s...)
+	}
+}
+
+func (w *wireWriter) header(h Header) {
+	w.uint64(h.Stamp)
+	w.string(h.FrameID)
+}
+
+func (w *wireWriter) vector3(v Vector3) {
+	w.float64(v.X)
+	w.float64(v.Y)
+	w.float64(v.Z)
+}
+
+func (w *wireWriter) pose(p Pose) {
+	w.vector3(p.Position)
+	w.float64(p.Orientation.X)
+	w.float64(p.Orientation.Y)
+	w.float64(p.Orientation.Z)
+	w.float64(p.Orientation.W)
+}
+
+// wireReader reads little-endian fields from a buffer.
+// Reads past the end return zero values and set err.
+type wireReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil || len(r.buf)-r.off < n {
+		if r.err == nil {
+			r.err = fmt.Errorf("%w: need %d bytes at offset %d, have %d", ErrTruncatedMessage, n, r.off, len(r.buf)-r.off)
+		}
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *wireReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *wireReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *wireReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *wireReader) float64() float64 {
+	return math.Float64frombits(r.uint64())
+}
+
+// count reads an element count and checks that elemSize*count bytes remain,
+// so a corrupt count can't trigger a huge allocation.
+func (r *wireReader) count(elemSize int) int {
+	n := int(r.uint16())
+	if r.err == nil && len(r.buf)-r.off < n*elemSize {
+		r.err = fmt.Errorf("%w: %d elements of %d bytes at offset %d", ErrTruncatedMessage, n, elemSize, r.off)
+	}
+	if r.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (r *wireReader) string() string {
+	n := r.count(1)
+	return string(r.next(n))
+}
+
+func (r *wireReader) header() Header {
+	return Header{Stamp: r.uint64(), FrameID: r.string()}
+}
+
+func (r *wireReader) vector3() Vector3 {
+	return Vector3{X: r.float64(), Y: r.float64(), Z: r.float64()}
+}
+
+func (r *wireReader) pose() Pose {
+	return Pose{
+		Position: r.vector3(),
+		Orientation: Quaternion{
+			X: r.float64(),
+			Y: r.float64(),
+			Z: r.float64(),
+			W: r.float64(),
+		},
+	}
+}
+
+// done returns the first read error, or an error if bytes are left over.
+func (r *wireReader) done() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.off != len(r.buf) {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidMessageSize, len(r.buf)-r.off)
+	}
+	return nil
+}
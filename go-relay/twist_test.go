@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	payload := EncodeTwist(&TwistMessage{Linear: Vector3{X: 1}, Timestamp: 1700000000000})
+	frame := EncodeEnvelope(MsgTypeTwist, 0, 42, payload)
+
+	corrupt := func(i int) []byte {
+		data := append([]byte(nil), frame...)
+		data[i] ^= 0xFF
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"valid", frame, nil},
+		{"too short", frame[:EnvelopeHeaderSize+EnvelopeCRCSize-1], ErrEnvelopeTooShort},
+		{"bad magic", corrupt(0), ErrInvalidMagic},
+		{"bad version", corrupt(1), ErrUnsupportedVersion},
+		{"corrupt type", corrupt(2), ErrChecksumMismatch},
+		{"corrupt seq", corrupt(5), ErrChecksumMismatch},
+		{"corrupt payload", corrupt(EnvelopeHeaderSize + 3), ErrChecksumMismatch},
+		{"corrupt crc", corrupt(len(frame) - 1), ErrChecksumMismatch},
+		{"truncated", frame[:len(frame)-1], ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := DecodeEnvelope(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("DecodeEnvelope() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if env.Type != MsgTypeTwist || env.Seq != 42 || !bytes.Equal(env.Payload, payload) {
+				t.Errorf("DecodeEnvelope() = %+v, want type %d seq 42 and the original payload", env, MsgTypeTwist)
+			}
+		})
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	twist := &TwistMessage{Linear: Vector3{Y: 0.5}, Timestamp: 1700000000000}
+	legacy := EncodeTwist(twist)
+	framed := EncodeEnvelope(MsgTypeTwist, 0, 7, legacy)
+
+	// A legacy Twist whose first byte happens to be the envelope magic
+	magicLegacy := append([]byte(nil), legacy...)
+	magicLegacy[0] = EnvelopeMagic
+
+	// A framed message with a bad CRC that isn't a legacy size
+	corrupt := append([]byte(nil), framed...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+
+	tests := []struct {
+		name    string
+		data    []byte
+		framed  bool
+		payload []byte
+		err     error
+	}{
+		{"framed", framed, true, legacy, nil},
+		{"legacy 56 bytes", legacy, false, legacy, nil},
+		{"legacy 48 bytes", legacy[:TwistMessageSizeLegacy], false, legacy[:TwistMessageSizeLegacy], nil},
+		{"legacy starting with magic", magicLegacy, false, magicLegacy, nil},
+		{"corrupt frame", corrupt, false, nil, ErrChecksumMismatch},
+		{"unknown size", legacy[:20], false, nil, ErrInvalidMessageSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := NewMessageRouter(nil)
+			env, framed, err := mr.decodeFrame("client", tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("decodeFrame() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if framed != tt.framed {
+				t.Errorf("decodeFrame() framed = %v, want %v", framed, tt.framed)
+			}
+			if env.Type != MsgTypeTwist || !bytes.Equal(env.Payload, tt.payload) {
+				t.Errorf("decodeFrame() = %+v, want a Twist with the expected payload", env)
+			}
+		})
+	}
+}
+
+func TestDecodeTwistRoundTrip(t *testing.T) {
+	want := &TwistMessage{
+		Linear:    Vector3{X: 1, Y: -2, Z: 0.25},
+		Angular:   Vector3{Z: 1.5},
+		Timestamp: 1700000000123,
+	}
+
+	got, err := DecodeTwist(EncodeTwist(want))
+	if err != nil {
+		t.Fatalf("DecodeTwist() error = %v", err)
+	}
+	if *got != *want {
+		t.Errorf("DecodeTwist() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Package main provides a deadman watchdog for operator command streams.
+//
+// Every web source (WebRTC peer or WebSocket client) that sends Twist messages
+// is tracked with the time of its last update. If a source that was last seen
+// commanding motion goes silent for longer than the configured timeout, the
+// watchdog trips and the router broadcasts an emergency stop to the Python
+// clients in the source's room so the robot doesn't keep executing a stale
+// command.
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// minWatchdogInterval bounds how often the watchdog scans its sources.
+const minWatchdogInterval = 20 * time.Millisecond
+
+// watchdogSource holds the last command state observed for one web source.
+type watchdogSource struct {
+	room     string    // Room the source was driving
+	lastSeen time.Time // When the last Twist arrived
+	moving   bool      // Whether the last Twist was non-zero
+}
+
+// Watchdog detects web sources that stopped sending Twist updates mid-motion.
+// Thread-safe for concurrent access from multiple goroutines.
+type Watchdog struct {
+	timeout time.Duration               // Silence window before tripping
+	sources map[string]*watchdogSource  // Tracked sources indexed by ID
+	mu      sync.Mutex                  // Protects sources
+	onTrip  func(sourceID, room string) // Called once per trip
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewWatchdog creates a watchdog that calls onTrip when a moving source has
+// been silent for longer than timeout.
+func NewWatchdog(timeout time.Duration, onTrip func(sourceID, room string)) *Watchdog {
+	return &Watchdog{
+		timeout: timeout,
+		sources: make(map[string]*watchdogSource),
+		onTrip:  onTrip,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start launches the background scan loop.
+func (w *Watchdog) Start() {
+	interval := w.timeout / 4
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.check(time.Now())
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+
+	log.Printf("[Watchdog] Started (timeout: %s)", w.timeout)
+}
+
+// Stop terminates the background scan loop.
+func (w *Watchdog) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
+
+// Touch records a Twist received from the given source in a room.
+func (w *Watchdog) Touch(sourceID, room string, twist *TwistMessage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	src, ok := w.sources[sourceID]
+	if !ok {
+		src = &watchdogSource{}
+		w.sources[sourceID] = src
+	}
+	src.room = room
+	src.lastSeen = time.Now()
+	src.moving = !twist.IsZero()
+}
+
+// check trips every moving source that has been silent past the timeout.
+// Sources that are stopped or have tripped are forgotten, so disconnected
+// clients don't need to be removed explicitly.
+func (w *Watchdog) check(now time.Time) {
+	tripped := make(map[string]string) // Source ID -> room
+
+	w.mu.Lock()
+	for id, src := range w.sources {
+		if now.Sub(src.lastSeen) < w.timeout {
+			continue
+		}
+		if src.moving {
+			tripped[id] = src.room
+		}
+		delete(w.sources, id)
+	}
+	w.mu.Unlock()
+
+	for id, room := range tripped {
+		log.Printf("[Watchdog] Source %s silent for over %s, injecting stop", id, w.timeout)
+		if w.onTrip != nil {
+			w.onTrip(id, room)
+		}
+	}
+}
@@ -4,6 +4,32 @@
 //   - /ws/signaling - WebRTC signaling with ping/pong keepalive
 //   - /ws/data      - Direct data transfer (alternative to DataChannel)
 //
+// /ws/signaling is an alternative to POST /offer: an "offer" creates a WebRTC
+// peer bound to the socket, the "answer" is sent back on the same socket and
+// ICE candidates are trickled in both directions as "ice" messages. When the
+// socket closes the peer is suspended; a new socket can resume it within the
+// grace period by sending an offer with the peer's "peer_id" and the
+// "session_token" from its answer. Otherwise the peer is removed.
+//
+// Both endpoints take the client type and room as query parameters, e.g.
+// /ws/data?type=python&room=robot1. The type is "web" (the default) or
+// "python"; other types are refused with 400. Messages are only relayed
+// within a room.
+//
+// The /ws/data welcome carries a "session" token. When a data socket drops,
+// the client keeps its ID, type, room and control lease for the grace period
+// and messages sent to it are buffered. Reconnecting with
+// /ws/data?session=<token>&received=<n> resumes the client: n is the number
+// of messages (not counting "welcome" and "pong") received so far in the
+// session, and the messages after it are replayed before live traffic. The
+// replay is bounded by count and age; see replay.go. Motion commands sent to
+// a robot are never replayed, so a robot doesn't act out stale commands.
+//
+// With an authenticator set, both endpoints require a token (see auth.go).
+// A connection without a valid one is closed with code 4401, and one asking
+// for type=python without the robot role with code 4403. Sessions and peers
+// can only be resumed by the identity that created them.
+//
 // Ping/Pong Mechanism:
 //   - Server sends ping every 30 seconds
 //   - Client must respond with pong within 10 seconds
@@ -12,13 +38,16 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
 )
 
 // WebSocket configuration
@@ -34,14 +63,23 @@ const (
 
 	// Max message size (1MB)
 	maxMessageSize = 1024 * 1024
+
+	// Max data message size; the largest registered message is far smaller
+	maxDataMessageSize = 64 * 1024
+
+	// Close codes for connections rejected by authentication and authorization
+	closeUnauthorized = 4401
+	closeForbidden    = 4403
 )
 
-// WebSocket upgrader with permissive CORS for development
+// WebSocket upgrader. Origins are checked against the WSManager's origin
+// policy before upgrading, so the handshake itself accepts any origin.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{bearerProtocol},
 	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
+		return true
 	},
 }
 
@@ -50,70 +88,257 @@ type SignalingMessage struct {
 	Type      string          `json:"type"`                // "offer", "answer", "ice", "ping", "pong", "error"
 	PeerID    string          `json:"peer_id,omitempty"`   // Assigned peer ID
 	PeerType  string          `json:"peer_type,omitempty"` // "web" or "python"
+	Room      string          `json:"room,omitempty"`      // Room (robot) the client joined
 	SDP       string          `json:"sdp,omitempty"`       // SDP offer/answer
 	Candidate json.RawMessage `json:"candidate,omitempty"` // ICE candidate
 	Error     string          `json:"error,omitempty"`     // Error message
 	Timestamp int64           `json:"timestamp,omitempty"` // For latency measurement
+
+	SessionToken string `json:"session_token,omitempty"` // Token for resuming the peer (answer, resuming offer)
 }
 
 // DataMessage represents a WebSocket data message
 type DataMessage struct {
-	Type      string `json:"type"`                // "twist", "status", "ping", "pong"
-	PeerID    string `json:"peer_id,omitempty"`   // Source peer ID
-	PeerType  string `json:"peer_type,omitempty"` // "web" or "python"
-	Data      []byte `json:"data,omitempty"`      // Binary data (base64 encoded in JSON)
-	Timestamp int64  `json:"timestamp,omitempty"` // Message timestamp
+	Type      string          `json:"type"`                // "twist", "message", "control", "estop", "status", "ping", "pong", "offer", "answer"
+	Action    string          `json:"action,omitempty"`    // Control or e-stop action
+	MsgType   string          `json:"msg_type,omitempty"`  // Registered ROS type name (for "message")
+	Payload   json.RawMessage `json:"payload,omitempty"`   // Message in JSON form (for "message")
+	PeerID    string          `json:"peer_id,omitempty"`   // Source peer ID
+	PeerType  string          `json:"peer_type,omitempty"` // "web" or "python"
+	Room      string          `json:"room,omitempty"`      // Room (robot) the client joined
+	Data      []byte          `json:"data,omitempty"`      // Binary data (base64 encoded in JSON)
+	Timestamp int64           `json:"timestamp,omitempty"` // Message timestamp
+	SDP       string          `json:"sdp,omitempty"`       // SDP for DataChannel renegotiation ("offer", "answer")
+	Session   string          `json:"session,omitempty"`   // Token for resuming the client ("welcome")
+	Resumed   bool            `json:"resumed,omitempty"`   // Whether the session was resumed ("welcome")
+	Replayed  int             `json:"replayed,omitempty"`  // Buffered messages that follow ("welcome")
+	Missed    uint64          `json:"missed,omitempty"`    // Messages evicted or not replayable, e.g. robot motion commands ("welcome")
 }
 
 // WSClient represents a connected WebSocket client
 type WSClient struct {
 	ID       string
 	PeerType string
+	Room     string
+	Identity *Identity // Authenticated client (nil without authentication)
+	Addr     string    // IP address the client connected from
 	Conn     *websocket.Conn
 	Send     chan []byte
 	manager  *WSManager
 	mu       sync.Mutex
+	peerID   string              // WebRTC peer negotiated over this signaling socket
+	answered bool                // Whether the peer's first answer has been sent
+	iceQueue []*SignalingMessage // Local candidates gathered before the answer was sent
+	session  *dataSession        // Resumable state of a data client
+}
+
+// dataSession is the state a data client keeps across reconnects
+type dataSession struct {
+	token    string
+	mu       sync.Mutex
+	replay   *ReplayBuffer
+	detached bool        // No socket attached; messages are only buffered
+	acked    uint64      // Last message queued before the socket dropped
+	expiry   *time.Timer // Removes a detached client unless it resumes
 }
 
 // WSManager manages WebSocket connections
 type WSManager struct {
-	// Signaling clients
+	// Signaling clients, indexed by ID and by room
 	signalingClients map[string]*WSClient
+	signalingRooms   map[string]map[string]*WSClient
 	signalingMu      sync.RWMutex
 
-	// Data clients
+	// Data clients, indexed by ID and by room
 	dataClients map[string]*WSClient
+	dataRooms   map[string]map[string]*WSClient
 	dataMu      sync.RWMutex
 
+	// Resumable data clients, indexed by session token (guarded by dataMu)
+	sessions     map[string]*WSClient
+	sessionGrace time.Duration
+	replayCount  int
+	replayAge    time.Duration
+
 	// Message router for data forwarding
 	router *MessageRouter
-	
+
 	// Peer manager for cross-protocol bridging (WebSocket <-> WebRTC)
 	peerManager *PeerManager
+
+	// Token check for new connections (nil disables)
+	auth Authenticator
+
+	// Allowed browser origins (nil allows any)
+	origins *OriginPolicy
+
+	// Python clients must connect with a robot certificate (see mtls.go)
+	robotCerts bool
+
+	// Message and connection attempt limits (nil disables)
+	limiter *RateLimiter
 }
 
 // NewWSManager creates a new WebSocket manager
 func NewWSManager(router *MessageRouter, peerManager *PeerManager) *WSManager {
 	return &WSManager{
 		signalingClients: make(map[string]*WSClient),
+		signalingRooms:   make(map[string]map[string]*WSClient),
 		dataClients:      make(map[string]*WSClient),
+		dataRooms:        make(map[string]map[string]*WSClient),
+		sessions:         make(map[string]*WSClient),
 		router:           router,
 		peerManager:      peerManager,
 	}
 }
 
+// SetSessionReplay sets how long a dropped data client may resume (0 removes
+// it at once) and bounds the messages buffered for replay.
+func (m *WSManager) SetSessionReplay(grace time.Duration, maxMessages int, maxAge time.Duration) {
+	m.dataMu.Lock()
+	defer m.dataMu.Unlock()
+	m.sessionGrace = grace
+	m.replayCount = maxMessages
+	m.replayAge = maxAge
+}
+
+// SetAuthenticator enables token authentication for new connections.
+func (m *WSManager) SetAuthenticator(auth Authenticator) {
+	m.auth = auth
+}
+
+// SetOriginPolicy sets the browser origins allowed to open WebSockets.
+func (m *WSManager) SetOriginPolicy(origins *OriginPolicy) {
+	m.origins = origins
+}
+
+// SetRateLimiter sets the message and connection attempt limits.
+func (m *WSManager) SetRateLimiter(limiter *RateLimiter) {
+	m.limiter = limiter
+}
+
+// RequireRobotCertificates only accepts Python clients that connect with a
+// verified client certificate for their room.
+func (m *WSManager) RequireRobotCertificates() {
+	m.robotCerts = true
+}
+
+// authenticate checks the token or client certificate of an upgrade
+// request. Rejected requests are closed with closeUnauthorized; ok is false
+// in that case.
+func (m *WSManager) authenticate(w http.ResponseWriter, r *http.Request, tag string) (identity *Identity, ok bool) {
+	if identity := certificateIdentity(r); identity != nil {
+		return identity, true
+	}
+	if m.auth == nil {
+		return nil, true
+	}
+
+	identity, err := m.auth.Authenticate(webSocketToken(r))
+	if err == nil {
+		return identity, true
+	}
+
+	log.Printf("[%s] Rejected connection from %s: %v", tag, r.RemoteAddr, err)
+	reject(w, r, closeUnauthorized)
+	return nil, false
+}
+
+// parsePeerType validates the type query parameter of a /ws/* request.
+// An empty value selects a web client.
+func parsePeerType(value string) (string, error) {
+	switch PeerType(value) {
+	case "":
+		return string(PeerTypeWeb), nil
+	case PeerTypeWeb, PeerTypePython:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid client type %q, use web or python", value)
+}
+
+// authorizeType checks that an identity may connect as peerType in room:
+// only robots may be Python clients, and with robot certificates required,
+// only over mTLS for the certificate's room. Rejected requests are closed
+// with closeForbidden.
+func (m *WSManager) authorizeType(w http.ResponseWriter, r *http.Request, tag string, identity *Identity, peerType, room string) bool {
+	if peerType != string(PeerTypePython) {
+		return true
+	}
+
+	if m.robotCerts {
+		err := checkRobotCertificate(identity, room)
+		if err == nil {
+			return true
+		}
+		log.Printf("[%s] Rejected python connection from %s: %v", tag, r.RemoteAddr, err)
+	} else {
+		if identity.Can(PermRobot) {
+			return true
+		}
+		log.Printf("[%s] Rejected python connection from %s (subject: %s, role: %s)",
+			tag, r.RemoteAddr, identity.Subject, identity.Role)
+	}
+	reject(w, r, closeForbidden)
+	return false
+}
+
+// reject refuses a WebSocket request. The request is still upgraded so the
+// client sees the close code rather than a failed handshake; plain HTTP
+// requests get the matching status.
+func reject(w http.ResponseWriter, r *http.Request, code int) {
+	status, reason := http.StatusUnauthorized, "unauthorized"
+	if code == closeForbidden {
+		status, reason = http.StatusForbidden, "forbidden"
+	}
+
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason), time.Now().Add(writeTimeout))
+	conn.Close()
+}
+
 // HandleSignalingWS handles WebSocket connections for signaling
 func (m *WSManager) HandleSignalingWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if !m.origins.Check(r, "WS-Signaling") {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if ok, retry := m.limiter.AllowConnect(r, "WS-Signaling"); !ok {
+		setRetryAfter(w, retry)
+		http.Error(w, "Too many connection attempts", http.StatusTooManyRequests)
+		return
+	}
+	identity, ok := m.authenticate(w, r, "WS-Signaling")
+	if !ok {
+		return
+	}
+
+	room, err := ParseRoom(r.URL.Query().Get("room"))
 	if err != nil {
-		log.Printf("[WS-Signaling] Upgrade error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Get peer type from query parameter
-	peerType := r.URL.Query().Get("type")
-	if peerType == "" {
-		peerType = "web"
+	peerType, err := parsePeerType(r.URL.Query().Get("type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !m.authorizeType(w, r, "WS-Signaling", identity, peerType, room) {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[WS-Signaling] Upgrade error: %v", err)
+		return
 	}
 
 	clientID := uuid.New().String()[:8]
@@ -121,6 +346,9 @@ func (m *WSManager) HandleSignalingWS(w http.ResponseWriter, r *http.Request) {
 	client := &WSClient{
 		ID:       clientID,
 		PeerType: peerType,
+		Room:     room,
+		Identity: identity,
+		Addr:     clientIP(r),
 		Conn:     conn,
 		Send:     make(chan []byte, 256),
 		manager:  m,
@@ -128,15 +356,17 @@ func (m *WSManager) HandleSignalingWS(w http.ResponseWriter, r *http.Request) {
 
 	m.signalingMu.Lock()
 	m.signalingClients[clientID] = client
+	addRoomClient(m.signalingRooms, client)
 	m.signalingMu.Unlock()
 
-	log.Printf("[WS-Signaling] Client connected: %s (type: %s)", clientID, peerType)
+	log.Printf("[WS-Signaling] Client connected: %s (type: %s, room: %s)", clientID, peerType, room)
 
 	// Send welcome message with peer ID
 	welcome := SignalingMessage{
 		Type:      "welcome",
 		PeerID:    clientID,
 		PeerType:  peerType,
+		Room:      room,
 		Timestamp: time.Now().UnixMilli(),
 	}
 	welcomeBytes, _ := json.Marshal(welcome)
@@ -147,51 +377,161 @@ func (m *WSManager) HandleSignalingWS(w http.ResponseWriter, r *http.Request) {
 	go client.readPumpSignaling()
 }
 
-// HandleDataWS handles WebSocket connections for data transfer
+// HandleDataWS handles WebSocket connections for data transfer.
+// A "session" query parameter naming a live session resumes that client.
 func (m *WSManager) HandleDataWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("[WS-Data] Upgrade error: %v", err)
+	if !m.origins.Check(r, "WS-Data") {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if ok, retry := m.limiter.AllowConnect(r, "WS-Data"); !ok {
+		setRetryAfter(w, retry)
+		http.Error(w, "Too many connection attempts", http.StatusTooManyRequests)
+		return
+	}
+	identity, ok := m.authenticate(w, r, "WS-Data")
+	if !ok {
 		return
 	}
+	query := r.URL.Query()
 
-	// Get peer type from query parameter
-	peerType := r.URL.Query().Get("type")
-	if peerType == "" {
-		peerType = "web"
+	// A resumed client keeps its type and room
+	previous := m.lookupSession(query.Get("session"))
+	if previous != nil && !sameIdentity(previous.Identity, identity) {
+		previous = nil
 	}
 
-	clientID := uuid.New().String()[:8]
+	var room, peerType string
+	if previous != nil {
+		room, peerType = previous.Room, previous.PeerType
+	} else {
+		var err error
+		if room, err = ParseRoom(query.Get("room")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if peerType, err = parsePeerType(query.Get("type")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !m.authorizeType(w, r, "WS-Data", identity, peerType, room) {
+			return
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[WS-Data] Upgrade error: %v", err)
+		return
+	}
 
 	client := &WSClient{
-		ID:       clientID,
+		ID:       uuid.New().String()[:8],
 		PeerType: peerType,
+		Room:     room,
+		Identity: identity,
+		Addr:     clientIP(r),
 		Conn:     conn,
-		Send:     make(chan []byte, 256),
 		manager:  m,
 	}
 
 	m.dataMu.Lock()
-	m.dataClients[clientID] = client
-	m.dataMu.Unlock()
-
-	log.Printf("[WS-Data] Client connected: %s (type: %s)", clientID, peerType)
+	var replay [][]byte
+	var missed uint64
+	resumed := previous != nil && m.sessions[previous.session.token] == previous
+	if resumed {
+		client.ID = previous.ID
+		client.session = previous.session
+		replay, missed = m.takeOver(previous, query.Get("received"))
+	} else {
+		client.session = &dataSession{
+			token:  uuid.New().String(),
+			replay: NewReplayBuffer(m.replayCount, m.replayAge),
+		}
+	}
+	if m.sessionGrace > 0 {
+		m.sessions[client.session.token] = client
+	}
 
-	// Send welcome message
+	// Queue the welcome and the replay before the client becomes visible to
+	// senders, so live traffic follows them in order
+	client.Send = make(chan []byte, 256+len(replay))
 	welcome := DataMessage{
 		Type:      "welcome",
-		PeerID:    clientID,
+		PeerID:    client.ID,
 		PeerType:  peerType,
+		Room:      room,
 		Timestamp: time.Now().UnixMilli(),
+		Session:   client.session.token,
+		Resumed:   resumed,
+		Replayed:  len(replay),
+		Missed:    missed,
 	}
 	welcomeBytes, _ := json.Marshal(welcome)
 	client.Send <- welcomeBytes
+	for _, data := range replay {
+		client.Send <- data
+	}
+
+	m.dataClients[client.ID] = client
+	addRoomClient(m.dataRooms, client)
+	m.dataMu.Unlock()
+
+	if resumed {
+		log.Printf("[WS-Data] Client resumed: %s (type: %s, room: %s, replayed: %d, missed: %d)",
+			client.ID, peerType, room, len(replay), missed)
+	} else {
+		log.Printf("[WS-Data] Client connected: %s (type: %s, room: %s)", client.ID, peerType, room)
+	}
+
+	// Bring the client up to date with cached robot state, unless the
+	// replay already covers everything it missed
+	if m.router != nil && (!resumed || missed > 0) {
+		m.router.HandleJoin(client.ID, PeerType(peerType), room)
+	}
 
 	// Start read/write pumps
 	go client.writePumpData()
 	go client.readPumpData()
 }
 
+// lookupSession returns the data client holding a session token, or nil.
+func (m *WSManager) lookupSession(token string) *WSClient {
+	if token == "" {
+		return nil
+	}
+
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+	return m.sessions[token]
+}
+
+// takeOver detaches a session from its previous client and returns the
+// messages to replay after the received count the client reported (or after
+// the last message queued to its old socket). Caller holds dataMu.
+func (m *WSManager) takeOver(previous *WSClient, received string) ([][]byte, uint64) {
+	s := previous.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := s.acked
+	if s.detached {
+		s.expiry.Stop()
+		s.detached = false
+	} else {
+		// The old socket has not noticed it is dead yet; closing it ends
+		// its pumps without removing the client
+		previous.Conn.Close()
+		from = s.replay.Last()
+	}
+	if n, err := strconv.ParseUint(received, 10, 64); err == nil && n <= s.replay.Last() {
+		from = n
+	}
+
+	return s.replay.Since(from, time.Now())
+}
+
 // readPumpSignaling reads messages from the WebSocket (signaling)
 func (c *WSClient) readPumpSignaling() {
 	defer func() {
@@ -215,6 +555,13 @@ func (c *WSClient) readPumpSignaling() {
 			}
 			break
 		}
+		switch c.manager.limiter.Admit(c.ID, c.Addr, len(message)) {
+		case RateDrop:
+			continue
+		case RateDisconnect:
+			c.closeRateLimited()
+			return
+		}
 
 		// Parse signaling message
 		var msg SignalingMessage
@@ -227,6 +574,14 @@ func (c *WSClient) readPumpSignaling() {
 	}
 }
 
+// closeRateLimited closes the connection of a client over the rate limit
+// with a policy violation.
+func (c *WSClient) closeRateLimited() {
+	c.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"),
+		time.Now().Add(writeTimeout))
+}
+
 // writePumpSignaling writes messages to the WebSocket (signaling)
 func (c *WSClient) writePumpSignaling() {
 	ticker := time.NewTicker(pingInterval)
@@ -266,16 +621,15 @@ func (c *WSClient) handleSignalingMessage(msg *SignalingMessage) {
 	switch msg.Type {
 	case "offer":
 		log.Printf("[WS-Signaling] Received offer from %s", c.ID)
-		// Forward to other clients or handle WebRTC offer
-		c.manager.broadcastSignaling(c.ID, msg)
+		c.handleOffer(msg)
 
 	case "answer":
 		log.Printf("[WS-Signaling] Received answer from %s", c.ID)
-		c.manager.broadcastSignaling(c.ID, msg)
+		c.handleAnswer(msg)
 
 	case "ice":
 		log.Printf("[WS-Signaling] Received ICE candidate from %s", c.ID)
-		c.manager.broadcastSignaling(c.ID, msg)
+		c.handleICE(msg)
 
 	case "ping":
 		// Respond with pong
@@ -292,14 +646,165 @@ func (c *WSClient) handleSignalingMessage(msg *SignalingMessage) {
 	}
 }
 
+// boundPeer returns the WebRTC peer negotiated over this socket, or nil
+func (c *WSClient) boundPeer() *Peer {
+	c.mu.Lock()
+	peerID := c.peerID
+	c.mu.Unlock()
+
+	if peerID == "" {
+		return nil
+	}
+	return c.manager.peerManager.GetPeer(peerID)
+}
+
+// handleOffer creates a WebRTC peer for the socket (or renegotiates the
+// existing one) and replies with the SDP answer. Local ICE candidates are
+// trickled to the socket as they are gathered. An offer carrying a peer ID
+// and session token resumes that suspended peer on this socket.
+func (c *WSClient) handleOffer(msg *SignalingMessage) {
+	peer := c.boundPeer()
+	created := false
+	if peer == nil && msg.SessionToken != "" {
+		if owner := c.manager.peerManager.GetPeer(msg.PeerID); owner != nil && sameIdentity(owner.Identity, c.Identity) {
+			peer = c.manager.peerManager.ResumePeer(msg.PeerID, msg.SessionToken)
+		}
+		if peer == nil {
+			c.sendSignalingError("Session not found: " + msg.PeerID)
+			return
+		}
+		c.bindPeer(peer)
+	} else if peer == nil {
+		created = true
+		peerType := PeerType(c.PeerType)
+		if peerType != PeerTypeWeb && peerType != PeerTypePython {
+			peerType = PeerTypeWeb
+		}
+
+		var err error
+		peer, err = c.manager.peerManager.CreatePeer(peerType, c.Room, c.Identity, c.Addr)
+		if err != nil {
+			c.sendSignalingError("Failed to create peer: " + err.Error())
+			return
+		}
+		c.bindPeer(peer)
+	}
+
+	answer, err := c.manager.peerManager.AcceptOffer(peer, msg.SDP)
+	if err != nil {
+		if created {
+			c.mu.Lock()
+			c.peerID = ""
+			c.mu.Unlock()
+			c.manager.peerManager.RemovePeer(peer.ID)
+		}
+		c.sendSignalingError(err.Error())
+		return
+	}
+
+	c.manager.SendToSignalingClient(c.ID, &SignalingMessage{
+		Type:      "answer",
+		PeerID:    peer.ID,
+		PeerType:  string(peer.Type),
+		Room:      peer.Room,
+		SDP:       answer.SDP,
+		Timestamp: time.Now().UnixMilli(),
+
+		SessionToken: peer.Token,
+	})
+
+	// Candidates are only useful to the client once it has the answer
+	c.mu.Lock()
+	c.answered = true
+	queued := c.iceQueue
+	c.iceQueue = nil
+	c.mu.Unlock()
+
+	for _, candidate := range queued {
+		c.manager.SendToSignalingClient(c.ID, candidate)
+	}
+
+	log.Printf("[WS-Signaling] Offer processed for peer %s (client: %s, room: %s)", peer.ID, c.ID, peer.Room)
+}
+
+// bindPeer makes the socket the signaling channel of a peer: its local ICE
+// candidates are trickled here, and it is suspended when the socket closes.
+func (c *WSClient) bindPeer(peer *Peer) {
+	peer.SetCandidateHandler(func(candidate webrtc.ICECandidateInit) {
+		init, _ := json.Marshal(candidate)
+		c.sendCandidate(&SignalingMessage{Type: "ice", PeerID: peer.ID, Candidate: init})
+	})
+
+	c.mu.Lock()
+	c.peerID = peer.ID
+	c.answered = false
+	c.iceQueue = nil
+	c.mu.Unlock()
+}
+
+// sendCandidate sends a local ICE candidate, or queues it until the answer
+// has been sent
+func (c *WSClient) sendCandidate(msg *SignalingMessage) {
+	c.mu.Lock()
+	if !c.answered {
+		c.iceQueue = append(c.iceQueue, msg)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	c.manager.SendToSignalingClient(c.ID, msg)
+}
+
+// handleAnswer applies an SDP answer to the socket's peer
+func (c *WSClient) handleAnswer(msg *SignalingMessage) {
+	peer := c.boundPeer()
+	if peer == nil {
+		c.sendSignalingError("No peer connection for answer")
+		return
+	}
+
+	if err := c.manager.peerManager.AcceptAnswer(peer, msg.SDP); err != nil {
+		c.sendSignalingError(err.Error())
+	}
+}
+
+// handleICE adds a remote ICE candidate to the socket's peer
+func (c *WSClient) handleICE(msg *SignalingMessage) {
+	peer := c.boundPeer()
+	if peer == nil {
+		c.sendSignalingError("No peer connection for ICE candidate")
+		return
+	}
+
+	var candidate webrtc.ICECandidateInit
+	if err := json.Unmarshal(msg.Candidate, &candidate); err != nil {
+		c.sendSignalingError("Invalid ICE candidate: " + err.Error())
+		return
+	}
+	if err := peer.Connection.AddICECandidate(candidate); err != nil {
+		c.sendSignalingError("Failed to add ICE candidate: " + err.Error())
+	}
+}
+
+// sendSignalingError reports a failed signaling request to the client
+func (c *WSClient) sendSignalingError(text string) {
+	log.Printf("[WS-Signaling] Error for %s: %s", c.ID, text)
+	c.manager.SendToSignalingClient(c.ID, &SignalingMessage{
+		Type:      "error",
+		Error:     text,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
 // readPumpData reads messages from the WebSocket (data)
 func (c *WSClient) readPumpData() {
 	defer func() {
-		c.manager.removeDataClient(c.ID)
+		c.manager.removeDataClient(c)
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadLimit(maxDataMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(pongTimeout + pingInterval))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(pongTimeout + pingInterval))
@@ -314,6 +819,13 @@ func (c *WSClient) readPumpData() {
 			}
 			break
 		}
+		switch c.manager.limiter.Admit(c.ID, c.Addr, len(message)) {
+		case RateDrop:
+			continue
+		case RateDisconnect:
+			c.closeRateLimited()
+			return
+		}
 
 		// Handle binary messages (raw Twist data)
 		if messageType == websocket.BinaryMessage {
@@ -369,55 +881,46 @@ func (c *WSClient) writePumpData() {
 	}
 }
 
-// handleBinaryData processes binary Twist messages
+// handleBinaryData processes binary frames (framed envelopes or legacy Twists)
 func (c *WSClient) handleBinaryData(data []byte) {
-	// Update router stats
-	if c.manager.router != nil {
-		c.manager.router.stats.MessagesReceived++
-	}
-
-	// Parse twist to check if it's valid
-	twist, err := DecodeTwist(data)
-	if err != nil {
-		log.Printf("[WS-Data] Invalid twist data from %s: %v", c.ID, err)
+	if c.manager.router == nil {
+		// No router: plain relay between WebSocket clients
+		c.manager.forwardData(c, data)
 		return
 	}
 
-	latency := twist.GetLatencyMs()
-	if !twist.IsZero() {
-		log.Printf("[WS-Data] Twist from %s (type: %s): lin.y=%.2f, ang.z=%.2f, latency=%dms",
-			c.ID, c.PeerType, twist.Linear.Y, twist.Angular.Z, latency)
-	}
-
-	// Forward to other WebSocket clients of opposite type
-	c.manager.forwardData(c.ID, c.PeerType, data)
-	
-	// Also forward to WebRTC peers of opposite type (cross-protocol bridging)
-	if c.manager.peerManager != nil {
-		var targetType PeerType
-		if c.PeerType == "web" {
-			targetType = PeerTypePython
-		} else {
-			targetType = PeerTypeWeb
-		}
-		sent := c.manager.peerManager.BroadcastToType(targetType, data)
-		if sent > 0 {
-			log.Printf("[WS-Data] Bridged to %d WebRTC %s client(s)", sent, targetType)
-			if c.manager.router != nil {
-				c.manager.router.stats.MessagesForwarded += uint64(sent)
-			}
-		}
-	}
+	// The router validates the frame and bridges it to both transports
+	c.manager.router.HandleFrame(c.ID, PeerType(c.PeerType), data)
 }
 
 // handleDataMessage processes JSON data messages
 func (c *WSClient) handleDataMessage(msg *DataMessage) {
 	switch msg.Type {
 	case "twist":
-		// Forward binary data
-		c.manager.forwardData(c.ID, c.PeerType, msg.Data)
+		// Same path as binary frames so commands are validated and bridged
+		c.handleBinaryData(msg.Data)
+
+	case "message":
+		if c.manager.router != nil {
+			c.manager.router.HandleJSONMessage(c.ID, PeerType(c.PeerType), msg.MsgType, msg.Payload)
+		}
+
+	case "control":
+		if c.manager.router != nil {
+			c.manager.router.HandleControl(c.ID, PeerType(c.PeerType), msg.Action)
+		}
+
+	case "estop":
+		if c.manager.router != nil {
+			c.manager.router.HandleEStop(c.ID, PeerType(c.PeerType), msg.Action)
+		}
 
 	case "ping":
+		// Client timestamp feeds the clock skew estimate for stale rejection
+		if c.manager.router != nil {
+			c.manager.router.ObservePing(c.ID, msg.Timestamp)
+		}
+
 		pong := DataMessage{
 			Type:      "pong",
 			PeerID:    c.ID,
@@ -431,96 +934,268 @@ func (c *WSClient) handleDataMessage(msg *DataMessage) {
 	}
 }
 
-// forwardData forwards data to WebSocket clients of the opposite peer type
-func (m *WSManager) forwardData(senderID, senderType string, data []byte) {
+// forwardData forwards data to WebSocket clients of the opposite peer type in the sender's room
+func (m *WSManager) forwardData(sender *WSClient, data []byte) {
 	m.dataMu.RLock()
 	defer m.dataMu.RUnlock()
 
 	targetType := "python"
-	if senderType == "python" {
+	if sender.PeerType == "python" {
 		targetType = "web"
 	}
 
 	forwarded := 0
-	for _, client := range m.dataClients {
-		if client.ID != senderID && client.PeerType == targetType {
-			select {
-			case client.Send <- data:
-				forwarded++
-			default:
+	for _, client := range m.dataRooms[sender.Room] {
+		if client.ID != sender.ID && client.PeerType == targetType {
+			sent, ok := client.queue(data)
+			if !ok {
 				log.Printf("[WS-Data] Send buffer full for %s", client.ID)
+			} else if sent {
+				forwarded++
 			}
 		}
 	}
 
 	if m.router != nil && forwarded > 0 {
-		m.router.stats.MessagesForwarded += uint64(forwarded)
+		m.router.stats.MessagesForwarded.Add(uint64(forwarded))
 	}
 }
 
-// BroadcastToType sends data to all WebSocket clients of a specific type
-// Used for cross-protocol bridging from WebRTC to WebSocket
-func (m *WSManager) BroadcastToType(targetType string, data []byte) int {
+// BroadcastToType sends data to all WebSocket clients of a specific type in a room
+// Used for cross-protocol bridging from WebRTC to WebSocket. Detached clients
+// only buffer the data and are not counted.
+func (m *WSManager) BroadcastToType(room, targetType string, data []byte) int {
 	m.dataMu.RLock()
 	defer m.dataMu.RUnlock()
 
-	sent := 0
-	for _, client := range m.dataClients {
+	count := 0
+	for _, client := range m.dataRooms[room] {
 		if client.PeerType == targetType {
-			select {
-			case client.Send <- data:
-				sent++
-			default:
+			sent, ok := client.queue(data)
+			if !ok {
 				log.Printf("[WS-Data] Send buffer full for %s", client.ID)
+			} else if sent {
+				count++
 			}
 		}
 	}
-	return sent
+	return count
 }
 
-// broadcastSignaling broadcasts signaling message to other clients
-func (m *WSManager) broadcastSignaling(senderID string, msg *SignalingMessage) {
+// SendToSignalingClient queues a signaling message for a single client.
+// Safe to call from WebRTC callbacks after the client has disconnected.
+func (m *WSManager) SendToSignalingClient(id string, msg *SignalingMessage) error {
 	m.signalingMu.RLock()
 	defer m.signalingMu.RUnlock()
 
-	msg.PeerID = senderID
-	msgBytes, _ := json.Marshal(msg)
+	client, ok := m.signalingClients[id]
+	if !ok {
+		return fmt.Errorf("signaling client %s not found", id)
+	}
 
-	for _, client := range m.signalingClients {
-		if client.ID != senderID {
-			select {
-			case client.Send <- msgBytes:
-			default:
-				log.Printf("[WS-Signaling] Send buffer full for %s", client.ID)
-			}
-		}
+	data, _ := json.Marshal(msg)
+	select {
+	case client.Send <- data:
+		return nil
+	default:
+		return fmt.Errorf("send buffer full for %s", id)
 	}
 }
 
 // removeSignalingClient removes a client from the signaling pool
 func (m *WSManager) removeSignalingClient(id string) {
 	m.signalingMu.Lock()
-	defer m.signalingMu.Unlock()
-
-	if client, ok := m.signalingClients[id]; ok {
+	client, ok := m.signalingClients[id]
+	if ok {
 		close(client.Send)
 		delete(m.signalingClients, id)
+		removeRoomClient(m.signalingRooms, client)
 		log.Printf("[WS-Signaling] Client disconnected: %s", id)
 	}
+	m.signalingMu.Unlock()
+
+	// Suspend the peer negotiated over this socket; it is removed unless a
+	// new socket resumes it within the grace period
+	if ok {
+		client.mu.Lock()
+		peerID := client.peerID
+		client.mu.Unlock()
+
+		if peerID != "" {
+			m.peerManager.Suspend(peerID)
+		}
+	}
 }
 
-// removeDataClient removes a client from the data pool
-func (m *WSManager) removeDataClient(id string) {
+// removeDataClient removes a client from the data pool when its socket
+// closes. With a grace period the client is detached instead: it stays in its
+// room and buffers messages until it resumes or the session expires.
+func (m *WSManager) removeDataClient(client *WSClient) {
 	m.dataMu.Lock()
-	defer m.dataMu.Unlock()
+	close(client.Send)
 
-	if client, ok := m.dataClients[id]; ok {
-		close(client.Send)
+	// A resumed session has already replaced this socket's client
+	if m.dataClients[client.ID] != client {
+		m.dataMu.Unlock()
+		return
+	}
+
+	if s := client.session; m.sessions[s.token] == client {
+		s.mu.Lock()
+		s.detached = true
+		s.acked = s.replay.Last()
+		s.expiry = time.AfterFunc(m.sessionGrace, func() { m.expireDataClient(client) })
+		s.mu.Unlock()
+		m.dataMu.Unlock()
+
+		log.Printf("[WS-Data] Client detached: %s (session kept for %s)", client.ID, m.sessionGrace)
+		if m.router != nil {
+			m.router.HandleSuspend(client.ID, client.Room, m.sessionGrace)
+		}
+		return
+	}
+
+	delete(m.dataClients, client.ID)
+	removeRoomClient(m.dataRooms, client)
+	m.dataMu.Unlock()
+
+	log.Printf("[WS-Data] Client disconnected: %s", client.ID)
+
+	// Notify the router outside the lock; it may broadcast to data clients
+	if m.router != nil {
+		m.router.HandleDisconnect(client.ID, client.Room)
+	}
+}
+
+// expireDataClient removes a detached client that did not resume in time.
+func (m *WSManager) expireDataClient(client *WSClient) {
+	m.dataMu.Lock()
+	if m.sessions[client.session.token] != client {
+		m.dataMu.Unlock()
+		return
+	}
+	delete(m.sessions, client.session.token)
+	delete(m.dataClients, client.ID)
+	removeRoomClient(m.dataRooms, client)
+	m.dataMu.Unlock()
+
+	log.Printf("[WS-Data] Client %s was not resumed within %s", client.ID, m.sessionGrace)
+
+	if m.router != nil {
+		m.router.HandleDisconnect(client.ID, client.Room)
+	}
+}
+
+// Kick disconnects a data client and drops its session so it can't resume.
+// Returns false if no such client is connected.
+func (m *WSManager) Kick(id string) bool {
+	m.dataMu.Lock()
+	client, ok := m.dataClients[id]
+	if !ok {
+		m.dataMu.Unlock()
+		return false
+	}
+	delete(m.sessions, client.session.token)
+
+	// A detached client has no socket left to close; remove it directly
+	s := client.session
+	s.mu.Lock()
+	detached := s.detached
+	if detached {
+		s.expiry.Stop()
 		delete(m.dataClients, id)
-		log.Printf("[WS-Data] Client disconnected: %s", id)
+		removeRoomClient(m.dataRooms, client)
+	}
+	s.mu.Unlock()
+	m.dataMu.Unlock()
+
+	if !detached {
+		// The read pump removes the client once the socket is closed
+		client.Conn.Close()
+	} else if m.router != nil {
+		m.router.HandleDisconnect(id, client.Room)
+	}
+	return true
+}
+
+// addRoomClient adds a client to a room index. Caller holds the matching lock.
+func addRoomClient(rooms map[string]map[string]*WSClient, client *WSClient) {
+	if rooms[client.Room] == nil {
+		rooms[client.Room] = make(map[string]*WSClient)
+	}
+	rooms[client.Room][client.ID] = client
+}
+
+// removeRoomClient removes a client from a room index. Caller holds the matching lock.
+func removeRoomClient(rooms map[string]map[string]*WSClient, client *WSClient) {
+	delete(rooms[client.Room], client.ID)
+	if len(rooms[client.Room]) == 0 {
+		delete(rooms, client.Room)
+	}
+}
+
+// GetDataClient returns the data client with the given ID, or nil if not connected
+func (m *WSManager) GetDataClient(id string) *WSClient {
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+	return m.dataClients[id]
+}
+
+// SendToDataClient queues data for a single data client
+func (m *WSManager) SendToDataClient(id string, data []byte) error {
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+
+	client, ok := m.dataClients[id]
+	if !ok {
+		return fmt.Errorf("data client %s not found", id)
+	}
+
+	if _, ok := client.queue(data); !ok {
+		return fmt.Errorf("send buffer full for %s", id)
+	}
+	return nil
+}
+
+// queue queues downstream data for a data client and records it for replay.
+// Motion commands to robots are numbered but never replayed, so a robot that
+// resumes doesn't act out commands that went stale while it was away. A
+// detached client only buffers data, and sent is false. ok is false if the
+// send buffer is full. Caller holds dataMu.
+func (c *WSClient) queue(data []byte) (sent, ok bool) {
+	if s := c.session; s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if c.PeerType == string(PeerTypePython) && IsMotionFrame(data) {
+			s.replay.Skip()
+		} else {
+			s.replay.Add(data, time.Now())
+		}
+		if s.detached {
+			return false, true
+		}
+	}
+
+	select {
+	case c.Send <- data:
+		return true, true
+	default:
+		return false, false
 	}
 }
 
+// isDetached reports whether a data client is waiting to resume its session.
+func (c *WSClient) isDetached() bool {
+	s := c.session
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.detached
+}
+
 // GetSignalingClientCount returns the number of connected signaling clients
 func (m *WSManager) GetSignalingClientCount() int {
 	m.signalingMu.RLock()
@@ -549,3 +1224,34 @@ func (m *WSManager) GetDataClientsByType() (web, python int) {
 	}
 	return
 }
+
+// RoomClientCount returns the number of data clients in a room
+func (m *WSManager) RoomClientCount(room string) int {
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+	return len(m.dataRooms[room])
+}
+
+// RoomCounts returns the number of connected data clients of each type per
+// room. Detached clients waiting to resume are not counted.
+func (m *WSManager) RoomCounts() map[string]RoomCount {
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+
+	counts := make(map[string]RoomCount, len(m.dataRooms))
+	for room, clients := range m.dataRooms {
+		var count RoomCount
+		for _, client := range clients {
+			if client.isDetached() {
+				continue
+			}
+			if client.PeerType == "web" {
+				count.Web++
+			} else {
+				count.Python++
+			}
+		}
+		counts[room] = count
+	}
+	return counts
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// detachedClient returns a data client whose socket has dropped.
+func detachedClient(m *WSManager, peerType PeerType) *WSClient {
+	client := &WSClient{
+		ID:       "client",
+		PeerType: string(peerType),
+		Room:     DefaultRoom,
+		Send:     make(chan []byte, 16),
+		manager:  m,
+		session: &dataSession{
+			token:    "token",
+			replay:   NewReplayBuffer(256, 0),
+			detached: true,
+			expiry:   time.AfterFunc(time.Hour, func() {}),
+		},
+	}
+	m.dataClients[client.ID] = client
+	addRoomClient(m.dataRooms, client)
+	m.sessions[client.session.token] = client
+	return client
+}
+
+func TestResumedRobotGetsNoMotionCommands(t *testing.T) {
+	twist := EncodeTwist(&TwistMessage{Linear: Vector3{X: 1}, Timestamp: 1700000000000})
+	stamped, _ := (&TwistStampedMessage{Twist: TwistMessage{Linear: Vector3{X: 1}}}).MarshalBinary()
+	gripper, _ := (&BoolMessage{Data: true}).MarshalBinary()
+	estop := (&EStopMessage{Type: "estop", Action: EStopActionState, Engaged: true}).Encode()
+
+	messages := [][]byte{
+		twist,
+		EncodeEnvelope(MsgTypeTwist, 0, 1, twist),
+		EncodeEnvelope(MsgTypeTwistStamped, 0, 2, stamped),
+		estop,
+		EncodeEnvelope(MsgTypeBool, 0, 3, gripper),
+		twist,
+	}
+
+	tests := []struct {
+		peerType PeerType
+		replayed int
+	}{
+		{PeerTypePython, 2}, // Only the e-stop state and the gripper command
+		{PeerTypeWeb, len(messages)},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.peerType), func(t *testing.T) {
+			m := NewWSManager(nil, nil)
+			client := detachedClient(m, tt.peerType)
+
+			for _, data := range messages {
+				if sent := m.BroadcastToType(DefaultRoom, string(tt.peerType), data); sent != 0 {
+					t.Fatalf("BroadcastToType() = %d for a detached client, want 0", sent)
+				}
+			}
+			if counts := m.RoomCounts()[DefaultRoom]; counts.Web != 0 || counts.Python != 0 {
+				t.Errorf("RoomCounts() = %+v for a detached client, want none", counts)
+			}
+
+			replay, missed := m.takeOver(client, "0")
+			if len(replay) != tt.replayed || missed != uint64(len(messages)-tt.replayed) {
+				t.Fatalf("takeOver() replayed %d, missed %d; want %d, %d",
+					len(replay), missed, tt.replayed, len(messages)-tt.replayed)
+			}
+			for _, data := range replay {
+				if tt.peerType == PeerTypePython && IsMotionFrame(data) {
+					t.Errorf("motion command replayed to a robot: %x", data)
+				}
+			}
+		})
+	}
+}
+
+func TestIsMotionFrame(t *testing.T) {
+	twist := EncodeTwist(&TwistMessage{Timestamp: 1})
+	joy, _ := (&JoyMessage{Axes: []float32{0.5}}).MarshalBinary()
+	odom, _ := (&OdometryMessage{}).MarshalBinary()
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"legacy twist", twist, true},
+		{"legacy twist without timestamp", twist[:TwistMessageSizeLegacy], true},
+		{"framed twist", EncodeEnvelope(MsgTypeTwist, 0, 1, twist), true},
+		{"framed joy", EncodeEnvelope(MsgTypeJoy, 0, 1, joy), true},
+		{"framed odometry", EncodeEnvelope(MsgTypeOdometry, 0, 1, odom), false},
+		{"json", []byte(`{"type":"estop","action":"state","engaged":true,"x":"padding"}`), false},
+		{"empty", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsMotionFrame(tt.data); got != tt.want {
+				t.Errorf("IsMotionFrame() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,9 @@
  *   - DataChannel message handling
  *   - Binary message support
  *   - Connection state monitoring
+ *   - Robot camera and microphone tracks (relay renegotiates over the DataChannel)
+ *   - Push-to-talk audio to the robot
+ *   - Session resumption (ICE restart) when the connection fails
  *   - Event-based callbacks
  * 
  * @module WebRTCClient
@@ -16,6 +19,7 @@
  * @example
  * const client = new WebRTCClient('http://localhost:8080');
  * client.onMessage = (data) => console.log('Received:', data);
+ * client.onTrack = (track, streams) => { video.srcObject = streams[0]; };
  * await client.connect();
  * client.send(twistMessage.encode());
  */
@@ -44,6 +48,7 @@ class WebRTCClient {
      * @param {string} [options.dataChannelLabel] - DataChannel label
      * @param {number} [options.reconnectAttempts] - Number of reconnection attempts
      * @param {number} [options.reconnectDelay] - Delay between reconnection attempts (ms)
+     * @param {string} [options.token] - Bearer token for relays that require authentication
      */
     constructor(relayUrl = 'http://localhost:8080', options = {}) {
         this.relayUrl = relayUrl;
@@ -63,10 +68,14 @@ class WebRTCClient {
         // Connection state
         this._state = ConnectionState.DISCONNECTED;
         this._peerId = null;
+        this._sessionToken = null;
+        this._resuming = false;
 
         // WebRTC components
         this._pc = null;
         this._dc = null;
+        this._audioSender = null;
+        this._mic = null;
 
         // Callbacks
         this.onMessage = null;
@@ -74,6 +83,7 @@ class WebRTCClient {
         this.onError = null;
         this.onOpen = null;
         this.onClose = null;
+        this.onTrack = null;
 
         // Statistics
         this._stats = {
@@ -184,6 +194,9 @@ class WebRTCClient {
             });
             this._setupDataChannel();
 
+            // Audio for push-to-talk; the microphone is attached while talking
+            this._audioSender = this._pc.addTransceiver('audio', { direction: 'sendrecv' }).sender;
+
             // Create offer
             console.log('[WebRTC] Creating offer...');
             const offer = await this._pc.createOffer();
@@ -234,7 +247,19 @@ class WebRTCClient {
 
             switch (state) {
                 case 'failed':
-                    this._setState(ConnectionState.FAILED);
+                    // Try to keep the same peer (and control) before giving up
+                    if (this._sessionToken && !this._resuming) {
+                        this._resume();
+                    } else {
+                        this._setState(ConnectionState.FAILED);
+                    }
+                    break;
+                case 'connected':
+                    if (this._resuming) {
+                        this._resuming = false;
+                        console.log('[WebRTC] Session resumed');
+                        this._setState(ConnectionState.CONNECTED);
+                    }
                     break;
                 case 'closed':
                     this._setState(ConnectionState.CLOSED);
@@ -246,6 +271,14 @@ class WebRTCClient {
             console.log(`[WebRTC] ICE state: ${this._pc.iceConnectionState}`);
         };
 
+        this._pc.ontrack = (event) => {
+            console.log(`[WebRTC] Track received: ${event.track.kind} ${event.track.id}`);
+
+            if (this.onTrack) {
+                this.onTrack(event.track, event.streams);
+            }
+        };
+
         this._pc.ondatachannel = (event) => {
             console.log(`[WebRTC] DataChannel received: ${event.channel.label}`);
             this._dc = event.channel;
@@ -298,6 +331,13 @@ class WebRTCClient {
                 this._stats.bytesReceived += data.byteLength;
             } else if (typeof data === 'string') {
                 this._stats.bytesReceived += data.length;
+
+                // Relay renegotiation (tracks added or removed)
+                if (data.startsWith('{"type":"offer"')) {
+                    this._handleRenegotiation(data);
+                    return;
+                }
+
                 // Convert to ArrayBuffer for consistency
                 const encoder = new TextEncoder();
                 data = encoder.encode(data).buffer;
@@ -309,6 +349,55 @@ class WebRTCClient {
         };
     }
 
+    /**
+     * Answer an SDP offer the relay sent over the DataChannel
+     * @private
+     * @param {string} text - {"type":"offer","sdp":"..."}
+     */
+    async _handleRenegotiation(text) {
+        try {
+            const offer = JSON.parse(text);
+            await this._pc.setRemoteDescription({ type: 'offer', sdp: offer.sdp });
+            const answer = await this._pc.createAnswer();
+            await this._pc.setLocalDescription(answer);
+            this._dc.send(JSON.stringify({ type: 'answer', sdp: answer.sdp }));
+            console.log('[WebRTC] Renegotiated with relay');
+        } catch (error) {
+            console.error('[WebRTC] Renegotiation failed:', error);
+        }
+    }
+
+    /**
+     * Resume the session with an ICE restart, keeping the peer ID
+     * @private
+     */
+    async _resume() {
+        console.log(`[WebRTC] Connection failed, resuming peer ${this._peerId}...`);
+        this._resuming = true;
+        this._setState(ConnectionState.CONNECTING);
+
+        try {
+            const offer = await this._pc.createOffer({ iceRestart: true });
+            await this._pc.setLocalDescription(offer);
+            await this._waitForIceGathering();
+
+            const answer = await this._sendOffer(true);
+            if (!answer) {
+                throw new Error('Session could not be resumed');
+            }
+            await this._pc.setRemoteDescription(answer);
+        } catch (error) {
+            console.error('[WebRTC] Resume failed:', error);
+            this._resuming = false;
+            this._sessionToken = null;
+            this._setState(ConnectionState.FAILED);
+
+            if (this.onError) {
+                this.onError(error);
+            }
+        }
+    }
+
     /**
      * Wait for ICE gathering to complete
      * @private
@@ -364,9 +453,10 @@ class WebRTCClient {
     /**
      * Send SDP offer to signaling server
      * @private
+     * @param {boolean} [resume] - Resume the current peer instead of creating one
      * @returns {Promise<RTCSessionDescription|null>}
      */
-    async _sendOffer() {
+    async _sendOffer(resume = false) {
         const url = `${this.relayUrl}/offer`;
         
         const payload = {
@@ -374,13 +464,20 @@ class WebRTCClient {
             type: 'offer',
             peerType: 'web'
         };
+        if (resume) {
+            payload.peerID = this._peerId;
+            payload.sessionToken = this._sessionToken;
+        }
 
         try {
+            const headers = { 'Content-Type': 'application/json' };
+            if (this.options.token) {
+                headers['Authorization'] = `Bearer ${this.options.token}`;
+            }
+
             const response = await fetch(url, {
                 method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json'
-                },
+                headers,
                 body: JSON.stringify(payload)
             });
 
@@ -393,6 +490,7 @@ class WebRTCClient {
             const data = await response.json();
             
             this._peerId = data.peerID;
+            this._sessionToken = data.sessionToken || null;
             console.log(`[WebRTC] Assigned peer ID: ${this._peerId}`);
 
             return new RTCSessionDescription({
@@ -443,11 +541,50 @@ class WebRTCClient {
         return this.send(encoder.encode(text).buffer);
     }
 
+    /**
+     * Start sending microphone audio to the robot (push-to-talk).
+     * The relay only forwards audio from the operator holding control.
+     * @returns {Promise<boolean>} True if the microphone is live
+     */
+    async startTalking() {
+        if (!this._audioSender) {
+            return false;
+        }
+
+        try {
+            if (!this._mic) {
+                this._mic = await navigator.mediaDevices.getUserMedia({ audio: true });
+            }
+            await this._audioSender.replaceTrack(this._mic.getAudioTracks()[0]);
+            return true;
+        } catch (error) {
+            console.error('[WebRTC] Microphone unavailable:', error);
+            return false;
+        }
+    }
+
+    /**
+     * Stop sending microphone audio
+     */
+    async stopTalking() {
+        if (this._audioSender) {
+            await this._audioSender.replaceTrack(null);
+        }
+    }
+
     /**
      * Close the WebRTC connection
      */
     close() {
         console.log('[WebRTC] Closing connection...');
+        this._sessionToken = null;
+        this._resuming = false;
+
+        if (this._mic) {
+            this._mic.getTracks().forEach(track => track.stop());
+            this._mic = null;
+        }
+        this._audioSender = null;
 
         if (this._dc) {
             this._dc.close();
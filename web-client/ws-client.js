@@ -37,12 +37,14 @@ class WSDataClient {
      * @param {number} [options.pingInterval=25000] - Ping interval (ms)
      * @param {number} [options.reconnectDelay=2000] - Reconnection delay (ms)
      * @param {number} [options.maxReconnectAttempts=5] - Max reconnection attempts
+     * @param {string} [options.token] - Bearer token for relays that require authentication
      */
     constructor(baseUrl, options = {}) {
         this.peerType = options.peerType || 'web';
         this.pingInterval = options.pingInterval || 25000;
         this.reconnectDelay = options.reconnectDelay || 2000;
         this.maxReconnectAttempts = options.maxReconnectAttempts || 5;
+        this.token = options.token || null;
         
         // Convert HTTP URL to WebSocket URL
         this.url = baseUrl
@@ -56,6 +58,10 @@ class WSDataClient {
         this._peerId = null;
         this._reconnectCount = 0;
         
+        // Session resumption: the relay replays what we missed while away
+        this._session = null;
+        this._received = 0;     // Messages received in this session (excluding welcome/pong)
+        
         // Ping/pong
         this._pingTimer = null;
         this._lastPongTime = null;
@@ -129,7 +135,15 @@ class WSDataClient {
         
         return new Promise((resolve) => {
             try {
-                this._ws = new WebSocket(this.url);
+                let url = this.url;
+                if (this._session) {
+                    url += '&session=' + encodeURIComponent(this._session) + '&received=' + this._received;
+                }
+                // Browsers can't set headers on WebSocket requests; the relay
+                // reads the token from the subprotocol that follows "bearer"
+                this._ws = this.token
+                    ? new WebSocket(url, ['bearer', this.token])
+                    : new WebSocket(url);
                 this._ws.binaryType = 'arraybuffer';
                 
                 this._ws.onopen = () => {
@@ -164,7 +178,16 @@ class WSDataClient {
                 this._ws.onclose = (event) => {
                     console.log('[WSClient] Connection closed:', event.code, event.reason);
                     this._stopPing();
-                    
+
+                    // Rejected by the relay's authentication
+                    if (event.code === 4401 && this.onError) {
+                        this.onError(new Error('Unauthorized: check the access token'));
+                    }
+                    // Authenticated, but the token's role may not connect as this type
+                    if (event.code === 4403 && this.onError) {
+                        this.onError(new Error('Forbidden: role may not connect as ' + this.peerType));
+                    }
+
                     if (this._state === WSState.CONNECTING) {
                         this._setState(WSState.FAILED);
                         resolve(false);
@@ -202,6 +225,7 @@ class WSDataClient {
     _handleMessage(data) {
         // Binary data (Twist message)
         if (data instanceof ArrayBuffer) {
+            this._received++;
             this.stats.messagesReceived++;
             this.stats.bytesReceived += data.byteLength;
             
@@ -218,7 +242,16 @@ class WSDataClient {
             switch (msg.type) {
                 case 'welcome':
                     this._peerId = msg.peer_id;
-                    console.log('[WSClient] Welcome, peer ID:', this._peerId);
+                    if (!msg.resumed) {
+                        this._received = 0;
+                    }
+                    this._session = msg.session || null;
+                    if (msg.resumed) {
+                        console.log('[WSClient] Session resumed, peer ID:', this._peerId,
+                            'replayed:', msg.replayed || 0, 'missed:', msg.missed || 0);
+                    } else {
+                        console.log('[WSClient] Welcome, peer ID:', this._peerId);
+                    }
                     break;
                     
                 case 'pong':
@@ -228,6 +261,7 @@ class WSDataClient {
                     break;
                     
                 default:
+                    this._received++;
                     console.log('[WSClient] Message:', msg);
             }
         } catch (e) {
@@ -344,6 +378,8 @@ class WSDataClient {
         }
         
         this._peerId = null;
+        this._session = null;
+        this._received = 0;
     }
 }
 